@@ -0,0 +1,306 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+const (
+	BUDGET_POLICY_ERROR = "error"
+	BUDGET_POLICY_WARN  = "warn"
+)
+
+const PKG_BUDGET_KEY = "pkg.size_budget"
+const TARGET_BUDGET_PREFIX = "target.region_budget."
+const TARGET_BUDGET_POLICY = "target.budget_policy"
+
+// Finds the memory region with the specified name.  Viper lowercases keys, so
+// the comparison is case-insensitive.
+func findMemSection(memSections map[string]*MemSection,
+	name string) *MemSection {
+
+	for secName, sec := range memSections {
+		if strings.EqualFold(secName, name) {
+			return sec
+		}
+	}
+
+	return nil
+}
+
+// Converts a budget string into a byte count.  A budget is either an absolute
+// size (e.g., 12k) or a percentage of the region's length (e.g., 90%).
+func parseBudget(budgetStr string, sec *MemSection) (uint64, error) {
+	str := strings.TrimSpace(budgetStr)
+	if strings.HasSuffix(str, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(str, "%"), 64)
+		if err != nil || pct < 0 {
+			return 0, util.FmtNewtError("Invalid size budget: %s", budgetStr)
+		}
+		return uint64(float64(sec.EndOff-sec.Offset) * pct / 100), nil
+	}
+
+	return ParseSizeString(str)
+}
+
+func (b *Builder) budgetPolicy() (string, error) {
+	policy := b.target.Vars[TARGET_BUDGET_POLICY]
+	switch policy {
+	case "":
+		return BUDGET_POLICY_ERROR, nil
+	case BUDGET_POLICY_ERROR, BUDGET_POLICY_WARN:
+		return policy, nil
+	default:
+		return "", util.FmtNewtError("Invalid %s: %s; must be %s or %s",
+			TARGET_BUDGET_POLICY, policy, BUDGET_POLICY_ERROR,
+			BUDGET_POLICY_WARN)
+	}
+}
+
+func (b *Builder) regionBudgets() map[string]string {
+	budgets := map[string]string{}
+	for k, v := range b.target.Vars {
+		if strings.HasPrefix(k, TARGET_BUDGET_PREFIX) {
+			budgets[strings.TrimPrefix(k, TARGET_BUDGET_PREFIX)] = v
+		}
+	}
+
+	return budgets
+}
+
+func (b *Builder) hasBudgets() bool {
+	if len(b.regionBudgets()) > 0 {
+		return true
+	}
+
+	for _, bpkg := range b.Packages {
+		if len(newtutil.GetStringMapFlat(bpkg.Viper, PKG_BUDGET_KEY)) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k, _ := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// The elf file has no linker script memory regions, only the text, data, and
+// bss regions of the size utility.  Budgets for the usual flash and RAM
+// regions count the elf regions which occupy them: initialized data takes
+// space in both.
+var elfRegionAliases = map[string][]string{
+	"FLASH": {ELF_REGION_TEXT, ELF_REGION_DATA},
+	"RAM":   {ELF_REGION_DATA, ELF_REGION_BSS},
+}
+
+// Determines which regions of the size data count against a budget for the
+// named region.
+//
+// @return []string             The names of the regions to add up; nil if
+//                                  the region is unknown.
+//         *MemSection          The region itself, if the size data has it;
+//                                  needed for percentage budgets.
+func budgetRegions(region string, memSections map[string]*MemSection,
+	elfSource bool) ([]string, *MemSection) {
+
+	if sec := findMemSection(memSections, region); sec != nil {
+		return []string{sec.Name}, sec
+	}
+
+	if elfSource {
+		if aliases, ok := elfRegionAliases[strings.ToUpper(region)]; ok {
+			return aliases, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Checks the used size of a single region or package against its budget.
+// When the sizes come from the elf file, a budget which can't be evaluated
+// (an unknown region, or a percentage of a region whose length is unknown)
+// produces a warning rather than an error.
+//
+// @return string               A description of the violation; "" if the
+//                                  budget is met.
+func checkBudget(owner string, region string, budgetStr string,
+	sizes map[string]uint32, memSections map[string]*MemSection,
+	elfSource bool) (string, error) {
+
+	regions, sec := budgetRegions(region, memSections, elfSource)
+	if regions == nil {
+		if elfSource {
+			util.StatusMessage(util.VERBOSITY_QUIET, "Warning: ignoring "+
+				"size budget for %s: memory region %s is not known from "+
+				"the elf file\n", owner, region)
+			return "", nil
+		}
+		return "", util.FmtNewtError("Size budget for %s refers to "+
+			"unknown memory region: %s", owner, region)
+	}
+
+	if sec == nil && strings.HasSuffix(strings.TrimSpace(budgetStr), "%") {
+		util.StatusMessage(util.VERBOSITY_QUIET, "Warning: ignoring size "+
+			"budget for %s: the length of memory region %s is not known "+
+			"from the elf file\n", owner, region)
+		return "", nil
+	}
+
+	var used uint64 = 0
+	for _, r := range regions {
+		used += uint64(sizes[r])
+	}
+
+	var budget uint64
+	var err error
+	if sec != nil {
+		budget, err = parseBudget(budgetStr, sec)
+		region = sec.Name
+	} else {
+		budget, err = ParseSizeString(strings.TrimSpace(budgetStr))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if used <= budget {
+		return "", nil
+	}
+
+	return fmt.Sprintf("%s (%s): %d bytes used; budget is %s (%d bytes); "+
+		"over by %d bytes", owner, region, used, budgetStr, budget,
+		used-budget), nil
+}
+
+// Compares the sizes of the linked image against the package and region
+// budgets.
+//
+// @return []string             Descriptions of each exceeded budget.
+func (b *Builder) budgetViolations(pkgSizes map[string]*PkgSize,
+	memSections map[string]*MemSection, elfSource bool) ([]string, error) {
+
+	violations := []string{}
+
+	// Region budgets apply to the total of everything linked into the region.
+	totals := map[string]uint32{}
+	for _, pkgSize := range pkgSizes {
+		for region, size := range pkgSize.Sizes {
+			totals[region] += size
+		}
+	}
+
+	regionBudgets := b.regionBudgets()
+	for _, region := range sortedKeys(regionBudgets) {
+		msg, err := checkBudget("region", region, regionBudgets[region],
+			totals, memSections, elfSource)
+		if err != nil {
+			return nil, err
+		}
+		if msg != "" {
+			violations = append(violations, msg)
+		}
+	}
+
+	for _, bpkg := range b.sortedBuildPackages() {
+		pkgBudgets := newtutil.GetStringMapFlat(bpkg.Viper, PKG_BUDGET_KEY)
+		if len(pkgBudgets) == 0 {
+			continue
+		}
+
+		// Packages are identified in the size data by their archive name.
+		pkgSize := pkgSizes[filepath.Base(b.ArchivePath(bpkg.Name()))]
+		sizes := map[string]uint32{}
+		if pkgSize != nil {
+			sizes = pkgSize.Sizes
+		}
+		for _, region := range sortedKeys(pkgBudgets) {
+			msg, err := checkBudget(bpkg.Name(), region, pkgBudgets[region],
+				sizes, memSections, elfSource)
+			if err != nil {
+				return nil, err
+			}
+			if msg != "" {
+				violations = append(violations, msg)
+			}
+		}
+	}
+
+	return violations, nil
+}
+
+// Evaluates the linked image against the size budgets declared by packages
+// (pkg.size_budget.<region>) and by the target
+// (target.region_budget.<region>).  Depending on the target's budget policy,
+// a violation either fails the build or produces a warning.
+func (b *Builder) CheckBudgets() error {
+	if !b.hasBudgets() {
+		return nil
+	}
+
+	policy, err := b.budgetPolicy()
+	if err != nil {
+		return err
+	}
+
+	source := b.sizeSource(b.AppElfPath(), SIZE_SOURCE_AUTO)
+	pkgSizes, memSections, err := b.loadElfSizes(b.AppElfPath(), source)
+	if err != nil {
+		return err
+	}
+
+	violations, err := b.budgetViolations(pkgSizes, memSections,
+		source == SIZE_SOURCE_ELF)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("Size budget exceeded:\n")
+	for _, v := range violations {
+		buffer.WriteString("    " + v + "\n")
+	}
+
+	if policy == BUDGET_POLICY_WARN {
+		util.StatusMessage(util.VERBOSITY_QUIET, "Warning: %s",
+			buffer.String())
+		return nil
+	}
+
+	return util.NewNewtError(buffer.String())
+}
@@ -25,6 +25,7 @@ import (
 	"path/filepath"
 
 	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/syscfg"
 	"mynewt.apache.org/newt/newt/target"
 	"mynewt.apache.org/newt/newt/toolchain"
 	"mynewt.apache.org/newt/util"
@@ -40,6 +41,15 @@ type Builder struct {
 	compilerPkg  *pkg.LocalPackage
 	compilerInfo *toolchain.CompilerInfo
 
+	// Whether compiles emit stack usage (.su) files for stack analysis.
+	stackUsage bool
+
+	// Settings specified for this build only; nil if none.
+	overrides *BuildOverrides
+
+	// System configuration; nil until the build is prepared.
+	syscfg *syscfg.Cfg
+
 	target *target.Target
 }
 
@@ -197,11 +207,12 @@ func (b *Builder) newCompiler(bpkg *BuildPackage,
 	dstDir string) (*toolchain.Compiler, error) {
 
 	c, err := toolchain.NewCompiler(b.compilerPkg.BasePath(), dstDir,
-		b.target.BuildProfile)
+		b.BuildProfile())
 	if err != nil {
 		return nil, err
 	}
 	c.AddInfo(b.compilerInfo)
+	c.StackUsage = b.stackUsage
 
 	if bpkg != nil {
 		ci, err := bpkg.CompilerInfo(b)
@@ -216,6 +227,9 @@ func (b *Builder) newCompiler(bpkg *BuildPackage,
 	for _, bp := range b.Packages {
 		c.AddDeps(bp.CfgFilenames()...)
 	}
+	if b.syscfg != nil {
+		c.AddDeps(b.SyscfgHeaderPath())
+	}
 
 	return c, nil
 }
@@ -264,13 +278,15 @@ func (b *Builder) buildPackage(bpkg *BuildPackage) error {
 	return nil
 }
 
-func (b *Builder) link(elfName string) error {
+// Links the package archives, along with the specified object files, into an
+// executable.
+func (b *Builder) link(elfName string, objFiles []string) error {
 	c, err := b.newCompiler(b.appPkg, b.PkgBinDir(elfName))
 	if err != nil {
 		return err
 	}
 
-	pkgNames := []string{}
+	pkgNames := append([]string{}, objFiles...)
 	for _, bpkg := range b.Packages {
 		archivePath := b.ArchivePath(bpkg.Name())
 		if util.NodeExist(archivePath) {
@@ -310,6 +326,13 @@ func (b *Builder) PrepBuild() error {
 	}
 
 	b.Bsp = pkg.NewBspPackage(bspPkg)
+
+	// Allow settings to be conditioned on the architecture (e.g.,
+	// pkg.cflags.'ARCH_cortex_m4 || ARCH_cortex_m7').
+	if b.Bsp.Arch != "" {
+		b.AddFeature("ARCH_" + b.Bsp.Arch)
+	}
+
 	compilerPkg := b.resolveCompiler()
 	if compilerPkg == nil {
 		if b.Bsp.CompilerName == "" {
@@ -354,6 +377,15 @@ func (b *Builder) PrepBuild() error {
 		return err
 	}
 
+	// Resolve the system configuration and generate syscfg.h, which every
+	// source file includes, and sysinit.h.
+	if err := b.generateSyscfg(); err != nil {
+		return err
+	}
+	if err := b.generateSysinitHeader(); err != nil {
+		return err
+	}
+
 	// Populate the base set of compiler flags.  Flags from the following
 	// packages get applied to every source file:
 	//     * app (if present)
@@ -389,6 +421,17 @@ func (b *Builder) PrepBuild() error {
 
 	baseCi.AddCompilerInfo(targetCi)
 
+	// Every source file sees the system configuration, whether or not it
+	// includes <syscfg/syscfg.h> itself.
+	baseCi.Includes = append(baseCi.Includes, b.GeneratedIncludeDir())
+	baseCi.Cflags = append(baseCi.Cflags, "-include"+b.SyscfgHeaderPath())
+
+	// Flags specified for this build only come last, so that they take
+	// precedence.
+	if b.overrides != nil {
+		baseCi.Cflags = append(baseCi.Cflags, b.overrides.Cflags...)
+	}
+
 	// Note: Compiler flags get added when compiler is created.
 
 	// Read the BSP configuration.  These settings are necessary for the link
@@ -422,7 +465,18 @@ func (b *Builder) Build() error {
 		}
 	}
 
-	if err := b.link(b.AppElfPath()); err != nil {
+	// Generate and compile the call to each package's init function.
+	sysinitObjs, err := b.buildSysinit()
+	if err != nil {
+		return err
+	}
+
+	if err := b.link(b.AppElfPath(), sysinitObjs); err != nil {
+		return err
+	}
+
+	// Make sure the image fits within its size budgets.
+	if err := b.CheckBudgets(); err != nil {
 		return err
 	}
 
@@ -469,8 +523,13 @@ func (b *Builder) Test(p *pkg.LocalPackage) error {
 		}
 	}
 
+	sysinitObjs, err := b.buildSysinit()
+	if err != nil {
+		return err
+	}
+
 	testFilename := b.TestExePath(p.Name())
-	err = b.link(testFilename)
+	err = b.link(testFilename, sysinitObjs)
 	if err != nil {
 		return err
 	}
@@ -493,8 +552,27 @@ func (b *Builder) Test(p *pkg.LocalPackage) error {
 }
 
 func (b *Builder) Clean() error {
-	path := b.BinDir()
-	util.StatusMessage(util.VERBOSITY_VERBOSE, "Cleaning directory %s\n", path)
-	err := os.RemoveAll(path)
-	return err
+	paths := []string{b.BinDir()}
+
+	// Cleaning a target also cleans its builds with overrides and its stack
+	// analysis builds.
+	pattern := b.BinDir() + OVERRIDES_BIN_SEPARATOR + STACK_BIN_ID
+	if b.overrides == nil {
+		pattern = b.BinDir() + OVERRIDES_BIN_SEPARATOR + "*"
+	}
+	extraPaths, err := filepath.Glob(pattern)
+	if err != nil {
+		return util.NewNewtError(err.Error())
+	}
+	paths = append(paths, extraPaths...)
+
+	for _, path := range paths {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "Cleaning directory %s\n",
+			path)
+		if err := os.RemoveAll(path); err != nil {
+			return util.NewNewtError(err.Error())
+		}
+	}
+
+	return nil
 }
@@ -215,11 +215,10 @@ func (bpkg *BuildPackage) loadDeps(b *Builder,
 			return false, err
 		}
 
-		pkg, ok := proj.ResolveDependency(newDep).(*pkg.LocalPackage)
-		if !ok {
-			return false,
-				util.NewNewtError("Could not resolve package dependency " +
-					newDep.String())
+		pkg, err := proj.ResolveDependencyVersion(newDep)
+		if err != nil {
+			return false, util.FmtNewtError("%s (required by %s)",
+				err.(*util.NewtError).Text, bpkg.FullName())
 		}
 
 		if b.Packages[pkg] == nil {
@@ -35,8 +35,28 @@ func BinRoot() string {
 	return project.GetProject().Path() + "/bin"
 }
 
+// Builds with overrides get their own bin directory, so that they don't
+// clobber the target's normal build.  So do stack analysis builds, which are
+// compiled with different flags.
 func (b *Builder) BinDir() string {
-	return BinRoot() + "/" + b.target.ShortName()
+	dir := BinRoot() + "/" + b.target.ShortName()
+	if b.overrides != nil {
+		dir += OVERRIDES_BIN_SEPARATOR + b.overrides.Id()
+	}
+	if b.stackUsage {
+		dir += OVERRIDES_BIN_SEPARATOR + STACK_BIN_ID
+	}
+	return dir
+}
+
+// Files which newt generates for a build (e.g., syscfg.h) are kept in the
+// build's bin directory.
+func (b *Builder) GeneratedDir() string {
+	return b.BinDir() + "/generated"
+}
+
+func (b *Builder) GeneratedIncludeDir() string {
+	return b.GeneratedDir() + "/include"
 }
 
 func (b *Builder) PkgBinDir(pkgName string) string {
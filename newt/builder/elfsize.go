@@ -0,0 +1,461 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+/*
+ * Sources of size information.
+ */
+const (
+	SIZE_SOURCE_AUTO = ""
+	SIZE_SOURCE_MAP  = "map"
+	SIZE_SOURCE_ELF  = "elf"
+)
+
+/*
+ * Memory regions reported by the elf backend.  There is no linker script
+ * memory configuration in an elf file, so sections are grouped the same way
+ * the size utility does.
+ */
+const (
+	ELF_REGION_TEXT = "text"
+	ELF_REGION_DATA = "data"
+	ELF_REGION_BSS  = "bss"
+)
+
+/*
+ * Name of the package that symbols which can't be traced back to a package
+ * (e.g., libc) get attributed to.
+ */
+const ELF_PKG_OTHER = "*other*"
+
+/*
+ * Identifies the package archive and object file a symbol came from.
+ */
+type elfSymOwner struct {
+	pkgName string
+	objName string
+	weak    bool
+}
+
+/*
+ * The local symbols defined by an object file.  These identify the object in
+ * a linked elf file when several objects are compiled from source files with
+ * the same name.
+ */
+type elfObjLocals struct {
+	owner *elfSymOwner
+	syms  map[string]bool
+}
+
+/*
+ * Maps symbol names to the package which defines them, and source file names
+ * to the objects compiled from files of that name.
+ */
+type ElfObjIndex struct {
+	syms  map[string]*elfSymOwner
+	files map[string][]*elfObjLocals
+}
+
+func NewElfObjIndex() *ElfObjIndex {
+	return &ElfObjIndex{
+		syms:  map[string]*elfSymOwner{},
+		files: map[string][]*elfObjLocals{},
+	}
+}
+
+/*
+ * Records the symbols defined by the specified object file.
+ */
+func (idx *ElfObjIndex) AddObjFile(pkgName string, objFile string) error {
+	f, err := elf.Open(objFile)
+	if err != nil {
+		return util.NewNewtError(err.Error())
+	}
+	defer f.Close()
+
+	syms, err := f.Symbols()
+	if err != nil {
+		// No symbol table; nothing to index.
+		return nil
+	}
+
+	objName := filepath.Base(objFile)
+	var locals *elfObjLocals
+	for _, sym := range syms {
+		symType := elf.ST_TYPE(sym.Info)
+		bind := elf.ST_BIND(sym.Info)
+
+		if symType == elf.STT_FILE {
+			locals = &elfObjLocals{
+				owner: &elfSymOwner{
+					pkgName: pkgName,
+					objName: objName,
+				},
+				syms: map[string]bool{},
+			}
+			idx.files[sym.Name] = append(idx.files[sym.Name], locals)
+			continue
+		}
+
+		if sym.Section == elf.SHN_UNDEF || sym.Name == "" ||
+			symType == elf.STT_SECTION {
+			continue
+		}
+
+		if bind == elf.STB_LOCAL {
+			if locals != nil {
+				locals.syms[sym.Name] = true
+			}
+			continue
+		}
+		if bind != elf.STB_GLOBAL && bind != elf.STB_WEAK {
+			continue
+		}
+
+		// A strong definition overrides a weak one.
+		weak := bind == elf.STB_WEAK
+		cur := idx.syms[sym.Name]
+		if cur == nil || (cur.weak && !weak) {
+			idx.syms[sym.Name] = &elfSymOwner{
+				pkgName: pkgName,
+				objName: objName,
+				weak:    weak,
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Indexes every object file in the specified package bin directory.
+ */
+func (idx *ElfObjIndex) AddPkgDir(pkgName string, dir string) error {
+	if util.NodeNotExist(dir) {
+		return nil
+	}
+
+	return filepath.Walk(dir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || filepath.Ext(path) != ".o" {
+				return nil
+			}
+			return idx.AddObjFile(pkgName, path)
+		})
+}
+
+/*
+ * Finds the object that the specified local symbols of a linked elf file
+ * came from: of the objects compiled from a source file with the specified
+ * name, the one which defines the most of the symbols.
+ */
+func (idx *ElfObjIndex) fileOwner(fileName string,
+	localNames []string) *elfSymOwner {
+
+	if idx == nil {
+		return nil
+	}
+
+	var best *elfObjLocals
+	bestCount := -1
+	for _, obj := range idx.files[fileName] {
+		count := 0
+		for _, name := range localNames {
+			if obj.syms[name] {
+				count++
+			}
+		}
+		if count > bestCount {
+			best = obj
+			bestCount = count
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+	return best.owner
+}
+
+/*
+ * Determines the owner of each local symbol in a linked elf file's symbol
+ * table.  The local symbols of each object follow an STT_FILE symbol naming
+ * its source file.
+ */
+func (idx *ElfObjIndex) localOwners(syms []elf.Symbol) []*elfSymOwner {
+	owners := make([]*elfSymOwner, len(syms))
+
+	assign := func(start int, end int) {
+		names := []string{}
+		for i := start + 1; i < end; i++ {
+			if elf.ST_BIND(syms[i].Info) == elf.STB_LOCAL &&
+				syms[i].Name != "" {
+
+				names = append(names, syms[i].Name)
+			}
+		}
+
+		owner := idx.fileOwner(syms[start].Name, names)
+		for i := start + 1; i < end; i++ {
+			owners[i] = owner
+		}
+	}
+
+	start := -1
+	for i, _ := range syms {
+		if elf.ST_TYPE(syms[i].Info) == elf.STT_FILE {
+			if start >= 0 {
+				assign(start, i)
+			}
+			start = i
+		}
+	}
+	if start >= 0 {
+		assign(start, len(syms))
+	}
+
+	return owners
+}
+
+func (idx *ElfObjIndex) owner(sym *elf.Symbol,
+	localOwner *elfSymOwner) *elfSymOwner {
+
+	if idx == nil {
+		return nil
+	}
+
+	if elf.ST_BIND(sym.Info) == elf.STB_LOCAL {
+		return localOwner
+	}
+
+	return idx.syms[sym.Name]
+}
+
+func elfSectionRegion(sec *elf.Section) string {
+	if sec.Flags&elf.SHF_ALLOC == 0 {
+		return ""
+	}
+
+	if sec.Type == elf.SHT_NOBITS {
+		return ELF_REGION_BSS
+	}
+	if sec.Flags&elf.SHF_WRITE != 0 {
+		return ELF_REGION_DATA
+	}
+	return ELF_REGION_TEXT
+}
+
+/*
+ * Read section and symbol sizes straight from an elf file.  Symbols are
+ * attributed to packages using the supplied object index; symbols that are
+ * not in the index are attributed to ELF_PKG_OTHER, broken down by source
+ * file where the elf file records one.
+ */
+func ParseElfSizes(fileName string, idx *ElfObjIndex) (map[string]*PkgSize,
+	map[string]*MemSection, error) {
+
+	f, err := elf.Open(fileName)
+	if err != nil {
+		return nil, nil, util.NewNewtError("Elf file failed: " + err.Error())
+	}
+	defer f.Close()
+
+	/*
+	 * Each region spans the lowest to the highest address of its sections.
+	 */
+	memSections := map[string]*MemSection{}
+	for _, sec := range f.Sections {
+		region := elfSectionRegion(sec)
+		if region == "" || sec.Size == 0 {
+			continue
+		}
+
+		memSection := memSections[region]
+		if memSection == nil {
+			memSections[region] = MakeMemSection(region, sec.Addr,
+				sec.Size)
+		} else {
+			if sec.Addr < memSection.Offset {
+				memSection.Offset = sec.Addr
+			}
+			if sec.Addr+sec.Size > memSection.EndOff {
+				memSection.EndOff = sec.Addr + sec.Size
+			}
+		}
+	}
+
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, nil, util.NewNewtError("Elf file has no symbols: " +
+			err.Error())
+	}
+
+	localOwners := idx.localOwners(syms)
+
+	pkgSizes := map[string]*PkgSize{}
+	curFile := ""
+	for i, _ := range syms {
+		sym := &syms[i]
+
+		switch elf.ST_TYPE(sym.Info) {
+		case elf.STT_FILE:
+			// Local symbols following this one come from this source file.
+			curFile = sym.Name
+			continue
+		case elf.STT_SECTION:
+			continue
+		}
+
+		if sym.Size == 0 || sym.Section == elf.SHN_UNDEF ||
+			int(sym.Section) >= len(f.Sections) {
+			continue
+		}
+
+		region := elfSectionRegion(f.Sections[sym.Section])
+		if region == "" {
+			continue
+		}
+
+		pkgName := ELF_PKG_OTHER
+		objName := curFile
+		if owner := idx.owner(sym, localOwners[i]); owner != nil {
+			pkgName = owner.pkgName
+			objName = owner.objName
+		} else if elf.ST_BIND(sym.Info) != elf.STB_LOCAL {
+			objName = "*global*"
+		}
+
+		pkgSize := pkgSizes[pkgName]
+		if pkgSize == nil {
+			pkgSize = MakePkgSize(pkgName, memSections)
+			pkgSizes[pkgName] = pkgSize
+		}
+		pkgSize.Sizes[region] += uint32(sym.Size)
+		pkgSize.addSymbol(objName, sym.Name, region, uint32(sym.Size),
+			memSections)
+	}
+
+	for name, section := range memSections {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "Mem %s: 0x%x-0x%x\n",
+			name, section.Offset, section.EndOff)
+	}
+
+	return pkgSizes, memSections, nil
+}
+
+/*
+ * Builds an index of the symbols defined by each package's object files.
+ * Packages are named by archive, as they are in the map file.
+ */
+func (b *Builder) elfObjIndex() (*ElfObjIndex, error) {
+	idx := NewElfObjIndex()
+
+	for _, bpkg := range b.sortedBuildPackages() {
+		pkgName := filepath.Base(b.ArchivePath(bpkg.Name()))
+		if err := idx.AddPkgDir(pkgName,
+			b.PkgBinDir(bpkg.Name())); err != nil {
+
+			return nil, err
+		}
+	}
+
+	return idx, nil
+}
+
+/*
+ * Resolves the source of size data for the specified elf file.  If no source
+ * is specified, the map file is used if there is one and the target is not a
+ * sim target; otherwise the elf file is read directly.
+ */
+func (b *Builder) sizeSource(elfFile string, source string) string {
+	if source != SIZE_SOURCE_AUTO {
+		return source
+	}
+
+	if b.Bsp.Arch != "sim" && util.NodeExist(elfFile+".map") {
+		return SIZE_SOURCE_MAP
+	}
+	return SIZE_SOURCE_ELF
+}
+
+/*
+ * Reads size data for the specified elf file using the requested source.  If
+ * no source is specified, the map file is used if there is one and the
+ * target is not a sim target; otherwise the elf file is read directly.
+ */
+func (b *Builder) loadElfSizes(elfFile string, source string) (
+	map[string]*PkgSize, map[string]*MemSection, error) {
+
+	mapFile := elfFile + ".map"
+	source = b.sizeSource(elfFile, source)
+
+	log.Debugf("Reading sizes for %s from %s", elfFile, source)
+
+	switch source {
+	case SIZE_SOURCE_MAP:
+		return ParseMapFileSizes(mapFile)
+
+	case SIZE_SOURCE_ELF:
+		idx, err := b.elfObjIndex()
+		if err != nil {
+			return nil, nil, err
+		}
+		return ParseElfSizes(elfFile, idx)
+
+	default:
+		return nil, nil, util.FmtNewtError("Invalid size source: %s; "+
+			"must be %s or %s", source, SIZE_SOURCE_MAP, SIZE_SOURCE_ELF)
+	}
+}
+
+/*
+ * Loads size data to compare against.  An elf file without an accompanying
+ * map file is read with the elf backend, using this build's object files to
+ * attribute symbols to packages.
+ */
+func (b *Builder) loadOtherSizes(fileName string) (map[string]*PkgSize,
+	map[string]*MemSection, error) {
+
+	if strings.HasSuffix(fileName, ".elf") &&
+		util.NodeNotExist(fileName+".map") {
+
+		idx, err := b.elfObjIndex()
+		if err != nil {
+			return nil, nil, err
+		}
+		return ParseElfSizes(fileName, idx)
+	}
+
+	return LoadSizes(fileName)
+}
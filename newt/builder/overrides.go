@@ -0,0 +1,114 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Separates a target's name from the identifier of a set of overrides in the
+// name of the bin directory of a build with overrides.
+const OVERRIDES_BIN_SEPARATOR = "@"
+
+// Settings specified for a single build, applied on top of the target's.
+type BuildOverrides struct {
+	Features []string `json:"features,omitempty"`
+	Cflags   []string `json:"cflags,omitempty"`
+	Profile  string   `json:"profile,omitempty"`
+}
+
+func (o *BuildOverrides) IsEmpty() bool {
+	return o == nil ||
+		(len(o.Features) == 0 && len(o.Cflags) == 0 && o.Profile == "")
+}
+
+func (o *BuildOverrides) sortedFeatures() []string {
+	features := make([]string, len(o.Features))
+	copy(features, o.Features)
+	sort.Strings(features)
+	return features
+}
+
+func (o *BuildOverrides) String() string {
+	parts := []string{}
+	if o.Profile != "" {
+		parts = append(parts, "profile="+o.Profile)
+	}
+	if len(o.Features) > 0 {
+		parts = append(parts,
+			"features="+strings.Join(o.sortedFeatures(), ","))
+	}
+	if len(o.Cflags) > 0 {
+		parts = append(parts, "cflags="+strings.Join(o.Cflags, " "))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Returns a short identifier for the overrides.  Builds with the same
+// overrides share a bin directory; builds with different ones don't.
+func (o *BuildOverrides) Id() string {
+	// Flag order matters; feature order doesn't.
+	str := o.Profile + "\n" + strings.Join(o.sortedFeatures(), " ") + "\n" +
+		strings.Join(o.Cflags, "\n")
+	return fmt.Sprintf("%x", sha1.Sum([]byte(str)))[:8]
+}
+
+// Applies the specified overrides to this build.  This must be done before
+// the build is prepared.
+func (b *Builder) SetOverrides(o *BuildOverrides) error {
+	if b.Bsp != nil {
+		return util.NewNewtError("Cannot override settings of a build " +
+			"that has already been prepared")
+	}
+	if o.IsEmpty() {
+		b.overrides = nil
+		return nil
+	}
+
+	for _, feature := range o.Features {
+		if feature == "" {
+			return util.NewNewtError("Invalid feature override: \"\"")
+		}
+		b.AddFeature(feature)
+	}
+
+	b.overrides = o
+	return nil
+}
+
+// Returns the build profile in effect: the overridden one, if any, otherwise
+// the target's.  The target itself is left unchanged, since it is shared by
+// other builds.
+func (b *Builder) BuildProfile() string {
+	if b.overrides != nil && b.overrides.Profile != "" {
+		return b.overrides.Profile
+	}
+	return b.target.BuildProfile
+}
+
+// Returns the overrides applied to this build; nil if none.
+func (b *Builder) Overrides() *BuildOverrides {
+	return b.overrides
+}
@@ -21,6 +21,7 @@ package builder
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -31,13 +32,18 @@ import (
 	"mynewt.apache.org/newt/util"
 )
 
+const (
+	SIZE_FORMAT_TEXT = "text"
+	SIZE_FORMAT_JSON = "json"
+)
+
 /*
  * These are different memory regions as specified in linker script.
  */
 type MemSection struct {
-	Name   string
-	Offset uint64
-	EndOff uint64
+	Name   string `json:"name"`
+	Offset uint64 `json:"offset"`
+	EndOff uint64 `json:"end"`
 }
 type MemSectionArray []*MemSection
 
@@ -70,12 +76,58 @@ func (m *MemSection) PartOf(addr uint64) bool {
 	}
 }
 
+/*
+ * Size of a single symbol within an object file.
+ */
+type SymbolSize struct {
+	Name  string            `json:"name"`
+	Sizes map[string]uint32 `json:"sizes"` /* Sizes indexed by mem section name */
+}
+
+type SymbolSizeArray []*SymbolSize
+
+func (array SymbolSizeArray) Len() int {
+	return len(array)
+}
+
+func (array SymbolSizeArray) Less(i, j int) bool {
+	return array[i].Name < array[j].Name
+}
+
+func (array SymbolSizeArray) Swap(i, j int) {
+	array[i], array[j] = array[j], array[i]
+}
+
+/*
+ * We accumulate the size of object files within a library to elements in this.
+ */
+type ObjSize struct {
+	Name  string                 `json:"name"`
+	Sizes map[string]uint32      `json:"sizes"` /* Sizes indexed by mem section name */
+	Syms  map[string]*SymbolSize `json:"symbols,omitempty"`
+}
+
+type ObjSizeArray []*ObjSize
+
+func (array ObjSizeArray) Len() int {
+	return len(array)
+}
+
+func (array ObjSizeArray) Less(i, j int) bool {
+	return array[i].Name < array[j].Name
+}
+
+func (array ObjSizeArray) Swap(i, j int) {
+	array[i], array[j] = array[j], array[i]
+}
+
 /*
  * We accumulate the size of libraries to elements in this.
  */
 type PkgSize struct {
-	Name  string
-	Sizes map[string]uint32 /* Sizes indexed by mem section name */
+	Name  string              `json:"name"`
+	Sizes map[string]uint32   `json:"sizes"` /* Sizes indexed by mem section name */
+	Objs  map[string]*ObjSize `json:"objects,omitempty"`
 }
 
 type PkgSizeArray []*PkgSize
@@ -92,17 +144,136 @@ func (array PkgSizeArray) Swap(i, j int) {
 	array[i], array[j] = array[j], array[i]
 }
 
+func makeSizes(memSections map[string]*MemSection) map[string]uint32 {
+	sizes := make(map[string]uint32)
+	for secName, _ := range memSections {
+		sizes[secName] = 0
+	}
+	return sizes
+}
+
 func MakePkgSize(name string, memSections map[string]*MemSection) *PkgSize {
 	pkgSize := &PkgSize{
 		Name: name,
 	}
-	pkgSize.Sizes = make(map[string]uint32)
-	for secName, _ := range memSections {
-		pkgSize.Sizes[secName] = 0
-	}
+	pkgSize.Sizes = makeSizes(memSections)
+	pkgSize.Objs = make(map[string]*ObjSize)
 	return pkgSize
 }
 
+func (ps *PkgSize) addSymbol(objName string, symName string, region string,
+	size uint32, memSections map[string]*MemSection) {
+
+	objSize := ps.Objs[objName]
+	if objSize == nil {
+		objSize = &ObjSize{
+			Name:  objName,
+			Sizes: makeSizes(memSections),
+			Syms:  make(map[string]*SymbolSize),
+		}
+		ps.Objs[objName] = objSize
+	}
+	objSize.Sizes[region] += size
+
+	symSize := objSize.Syms[symName]
+	if symSize == nil {
+		symSize = &SymbolSize{
+			Name:  symName,
+			Sizes: makeSizes(memSections),
+		}
+		objSize.Syms[symName] = symSize
+	}
+	symSize.Sizes[region] += size
+}
+
+/*
+ * Prefixes of input section names which get stripped to obtain the name of
+ * the symbol the section contains (-ffunction-sections / -fdata-sections).
+ */
+var symSectionPrefixes = []string{
+	".text.",
+	".rodata.",
+	".data.",
+	".bss.",
+	".sbss.",
+	".sdata.",
+}
+
+/*
+ * An input section from the map file, along with the global symbols listed
+ * inside it.  Symbol sizes are only known after the next input section is
+ * read, so these are accumulated until then.
+ */
+type mapInputSection struct {
+	name    string
+	addr    uint64
+	size    uint64
+	srcFile string
+	symAddr []uint64
+	symName []string
+}
+
+func (mis *mapInputSection) objName() string {
+	tmpStrArr := strings.Split(mis.srcFile, "(")
+	if len(tmpStrArr) > 1 {
+		return strings.TrimSuffix(tmpStrArr[1], ")")
+	}
+	if strings.HasSuffix(mis.srcFile, ".o") {
+		return filepath.Base(mis.srcFile)
+	}
+	return ""
+}
+
+func (mis *mapInputSection) secSymName() string {
+	for _, prefix := range symSectionPrefixes {
+		if strings.HasPrefix(mis.name, prefix) {
+			return strings.TrimPrefix(mis.name, prefix)
+		}
+	}
+	return ""
+}
+
+/*
+ * Attributes the input section's size to the symbols it contains.
+ */
+func (mis *mapInputSection) flush(pkgSize *PkgSize, region string,
+	memSections map[string]*MemSection) {
+
+	objName := mis.objName()
+	if objName == "" {
+		return
+	}
+
+	if symName := mis.secSymName(); symName != "" || len(mis.symAddr) == 0 {
+		if symName == "" {
+			symName = mis.name
+		}
+		pkgSize.addSymbol(objName, symName, region, uint32(mis.size),
+			memSections)
+		return
+	}
+
+	/*
+	 * Section contains several symbols, e.g. .text without
+	 * -ffunction-sections.  Each symbol extends to the start of the next.
+	 */
+	end := mis.addr + mis.size
+	if mis.symAddr[0] > mis.addr {
+		pkgSize.addSymbol(objName, mis.name, region,
+			uint32(mis.symAddr[0]-mis.addr), memSections)
+	}
+	for i, addr := range mis.symAddr {
+		next := end
+		if i+1 < len(mis.symAddr) {
+			next = mis.symAddr[i+1]
+		}
+		if next > addr {
+			pkgSize.addSymbol(objName, mis.symName[i], region,
+				uint32(next-addr), memSections)
+		}
+	}
+}
+
 /*
  * Go through GCC generated mapfile, and collect info about symbol sizes
  */
@@ -119,6 +290,22 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, map[string]*MemSec
 	memSections := make(map[string]*MemSection)
 	pkgSizes := make(map[string]*PkgSize)
 
+	/*
+	 * Input section currently being processed, and the package / memory
+	 * region its contents are attributed to.
+	 */
+	var secName string = ""
+	var curSec *mapInputSection
+	var curPkg *PkgSize
+	var curRegion string
+	flushSec := func() {
+		if curSec != nil && curPkg != nil {
+			curSec.flush(curPkg, curRegion, memSections)
+		}
+		curSec = nil
+		curPkg = nil
+	}
+
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		switch state {
@@ -161,6 +348,7 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, map[string]*MemSec
 				/*
 				 * After this there is only discarded symbols
 				 */
+				flushSec()
 				state = 5
 				continue
 			}
@@ -175,8 +363,12 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, map[string]*MemSec
 				 * section name + symbol name, e.g.
 				 * .text.Reset_Handler
 				 *
-				 * ignore these for now
+				 * remember the latter; the address and size of the section
+				 * follow on the next line.
 				 */
+				if strings.HasPrefix(array[0], ".") {
+					secName = array[0]
+				}
 				continue
 			case 2:
 				/*
@@ -193,8 +385,20 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, map[string]*MemSec
 				 * 0x0000000020002e80      0x400
 				 * (that's the initial stack)
 				 *
-				 * ignore these for now
+				 * only symbol names are of interest; they are attributed to
+				 * the enclosing input section.
 				 */
+				if curSec != nil && !strings.HasPrefix(array[1], "0x") {
+					symAddr, err := strconv.ParseUint(array[0], 0, 64)
+					numSyms := len(curSec.symAddr)
+					if err == nil && symAddr >= curSec.addr &&
+						symAddr < curSec.addr+curSec.size &&
+						(numSyms == 0 || symAddr >= curSec.symAddr[numSyms-1]) {
+
+						curSec.symAddr = append(curSec.symAddr, symAddr)
+						curSec.symName = append(curSec.symName, array[1])
+					}
+				}
 				continue
 			case 3:
 				/*
@@ -219,6 +423,9 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, map[string]*MemSec
 					sizeStr = array[1]
 					srcFile = array[2]
 				}
+				if !strings.HasPrefix(array[0], "0x") {
+					secName = array[0]
+				}
 			case 4:
 				/*
 				 * section, address, size, name of file, e.g.
@@ -233,6 +440,9 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, map[string]*MemSec
 				addrStr = array[1]
 				sizeStr = array[2]
 				srcFile = array[3]
+				if !strings.HasPrefix(array[0], "0x") {
+					secName = array[0]
+				}
 			default:
 				continue
 			}
@@ -244,6 +454,7 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, map[string]*MemSec
 			if err != nil {
 				continue
 			}
+			flushSec()
 			if size == 0 {
 				continue
 			}
@@ -258,12 +469,23 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, map[string]*MemSec
 						pkgSizes[srcLib] = pkgSize
 					}
 					pkgSize.Sizes[name] += uint32(size)
+
+					curSec = &mapInputSection{
+						name:    secName,
+						addr:    addr,
+						size:    size,
+						srcFile: srcFile,
+					}
+					curPkg = pkgSize
+					curRegion = name
 					break
 				}
 			}
+			secName = ""
 		default:
 		}
 	}
+	flushSec()
 	file.Close()
 	for name, section := range memSections {
 		util.StatusMessage(util.VERBOSITY_VERBOSE, "Mem %s: 0x%x-0x%x\n",
@@ -273,68 +495,184 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, map[string]*MemSec
 	return pkgSizes, memSections, nil
 }
 
+func totalSize(sizes map[string]uint32) uint64 {
+	var total uint64 = 0
+	for _, size := range sizes {
+		total += uint64(size)
+	}
+	return total
+}
+
+func sortedMemSections(sectMap map[string]*MemSection) MemSectionArray {
+	memSections := make(MemSectionArray, 0, len(sectMap))
+	for _, sec := range sectMap {
+		memSections = append(memSections, sec)
+	}
+	sort.Sort(memSections)
+	return memSections
+}
+
+func sortedPkgSizes(libs map[string]*PkgSize) PkgSizeArray {
+	pkgSizes := make(PkgSizeArray, 0, len(libs))
+	for _, es := range libs {
+		pkgSizes = append(pkgSizes, es)
+	}
+	sort.Sort(pkgSizes)
+	return pkgSizes
+}
+
+func (ps *PkgSize) sortedObjs() ObjSizeArray {
+	objSizes := make(ObjSizeArray, 0, len(ps.Objs))
+	for _, objSize := range ps.Objs {
+		objSizes = append(objSizes, objSize)
+	}
+	sort.Sort(objSizes)
+	return objSizes
+}
+
+/*
+ * Symbols are ordered by descending size; the biggest consumers are the
+ * interesting ones.
+ */
+func (objSize *ObjSize) sortedSyms() SymbolSizeArray {
+	symSizes := make(SymbolSizeArray, 0, len(objSize.Syms))
+	for _, ss := range objSize.Syms {
+		symSizes = append(symSizes, ss)
+	}
+	sort.Sort(symSizes)
+	sort.Stable(sort.Reverse(symSizesByTotal{symSizes}))
+	return symSizes
+}
+
+type symSizesByTotal struct {
+	SymbolSizeArray
+}
+
+func (s symSizesByTotal) Less(i, j int) bool {
+	return totalSize(s.SymbolSizeArray[i].Sizes) <
+		totalSize(s.SymbolSizeArray[j].Sizes)
+}
+
+func sizeRow(memSections MemSectionArray, sizes map[string]uint32,
+	indent int, name string) string {
+
+	ret := ""
+	for _, sec := range memSections {
+		ret += fmt.Sprintf("%7d ", sizes[sec.Name])
+	}
+	ret += fmt.Sprintf("%s%s\n", strings.Repeat(" ", indent), name)
+	return ret
+}
+
 /*
- * Return a printable string containing size data for the libraries
+ * Return a printable string containing size data for the libraries.  If
+ * symbols is set, each library is broken down by object file and symbol.
  */
 func PrintSizes(libs map[string]*PkgSize,
-	sectMap map[string]*MemSection) (string, error) {
+	sectMap map[string]*MemSection, symbols bool) (string, error) {
 	ret := ""
 
 	/*
 	 * Order sections by offset, and display lib sizes in that order.
 	 */
-	memSections := make(MemSectionArray, len(sectMap))
-	var i int = 0
-	for _, sec := range sectMap {
-		memSections[i] = sec
-		i++
-	}
-	sort.Sort(memSections)
+	memSections := sortedMemSections(sectMap)
 
 	/*
 	 * Order libraries by name, and display them in that order.
 	 */
-	pkgSizes := make(PkgSizeArray, len(libs))
-	i = 0
-	for _, es := range libs {
-		pkgSizes[i] = es
-		i++
-	}
-	sort.Sort(pkgSizes)
+	pkgSizes := sortedPkgSizes(libs)
 
 	for _, sec := range memSections {
 		ret += fmt.Sprintf("%7s ", sec.Name)
 	}
 	ret += "\n"
 	for _, es := range pkgSizes {
-		for i := 0; i < len(memSections); i++ {
-			ret += fmt.Sprintf("%7d ", es.Sizes[memSections[i].Name])
+		ret += sizeRow(memSections, es.Sizes, 0, es.Name)
+		if !symbols {
+			continue
+		}
+		for _, objSize := range es.sortedObjs() {
+			ret += sizeRow(memSections, objSize.Sizes, 4, objSize.Name)
+			for _, ss := range objSize.sortedSyms() {
+				ret += sizeRow(memSections, ss.Sizes, 8, ss.Name)
+			}
 		}
-		ret += fmt.Sprintf("%s\n", es.Name)
 	}
 	return ret, nil
 }
 
-func (b *Builder) Size() error {
+/*
+ * Machine readable form of the size data.
+ */
+type SizeReport struct {
+	Sections []*MemSection `json:"sections"`
+	Pkgs     []*PkgSize    `json:"packages"`
+}
+
+func NewSizeReport(libs map[string]*PkgSize, sectMap map[string]*MemSection,
+	symbols bool) *SizeReport {
+
+	report := &SizeReport{
+		Sections: sortedMemSections(sectMap),
+	}
+	for _, es := range sortedPkgSizes(libs) {
+		if !symbols {
+			es = &PkgSize{
+				Name:  es.Name,
+				Sizes: es.Sizes,
+			}
+		}
+		report.Pkgs = append(report.Pkgs, es)
+	}
+
+	return report
+}
+
+/*
+ * Return a JSON string containing size data for the libraries.
+ */
+func PrintSizesJson(libs map[string]*PkgSize,
+	sectMap map[string]*MemSection, symbols bool) (string, error) {
+
+	buffer, err := json.MarshalIndent(NewSizeReport(libs, sectMap, symbols),
+		"", "  ")
+	if err != nil {
+		return "", util.NewNewtError(fmt.Sprintf("Cannot encode size "+
+			"report: %s", err.Error()))
+	}
+
+	return string(buffer) + "\n", nil
+}
+
+func (b *Builder) Size(symbols bool, format string, source string) error {
 	if b.target.App() == nil {
 		return util.NewNewtError("app package not specified for this target")
 	}
 
+	if format != SIZE_FORMAT_TEXT && format != SIZE_FORMAT_JSON {
+		return util.FmtNewtError("Invalid size output format: %s", format)
+	}
+
 	err := b.PrepBuild()
 	if err != nil {
 		return err
 	}
-	if b.Bsp.Arch == "sim" {
-		fmt.Println("'newt size' not supported for sim targets.")
-		return nil
-	}
-	mapFile := b.AppElfPath() + ".map"
 
-	pkgSizes, memSections, err := ParseMapFileSizes(mapFile)
+	pkgSizes, memSections, err := b.loadElfSizes(b.AppElfPath(), source)
 	if err != nil {
 		return err
 	}
-	output, err := PrintSizes(pkgSizes, memSections)
+
+	if format == SIZE_FORMAT_JSON {
+		output, err := PrintSizesJson(pkgSizes, memSections, symbols)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s", output)
+		return nil
+	}
+
+	output, err := PrintSizes(pkgSizes, memSections, symbols)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,385 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+/*
+ * Change in size of a single package, or of a whole memory region.
+ */
+type SizeDelta struct {
+	Name   string           `json:"name"`
+	Deltas map[string]int64 `json:"deltas"` /* Indexed by mem section name */
+	Total  int64            `json:"total"`
+}
+
+type SizeDeltaArray []*SizeDelta
+
+func absInt64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func (array SizeDeltaArray) Len() int {
+	return len(array)
+}
+
+/*
+ * Biggest absolute change first; ties are ordered by name.
+ */
+func (array SizeDeltaArray) Less(i, j int) bool {
+	ai := absInt64(array[i].Total)
+	aj := absInt64(array[j].Total)
+	if ai != aj {
+		return ai > aj
+	}
+	return array[i].Name < array[j].Name
+}
+
+func (array SizeDeltaArray) Swap(i, j int) {
+	array[i], array[j] = array[j], array[i]
+}
+
+type SizeDiff struct {
+	Sections []string       `json:"sections"`
+	Regions  SizeDeltaArray `json:"regions"`
+	Pkgs     SizeDeltaArray `json:"packages"`
+}
+
+/*
+ * Parses a size string.  A "k" or "m" suffix multiplies the value by 1024 or
+ * 1024*1024 respectively, e.g., 12k.
+ */
+func ParseSizeString(sizeStr string) (uint64, error) {
+	str := strings.ToLower(strings.TrimSpace(sizeStr))
+
+	var mult uint64 = 1
+	if strings.HasSuffix(str, "k") {
+		mult = 1024
+		str = strings.TrimSuffix(str, "k")
+	} else if strings.HasSuffix(str, "m") {
+		mult = 1024 * 1024
+		str = strings.TrimSuffix(str, "m")
+	}
+
+	val, err := strconv.ParseUint(str, 0, 64)
+	if err != nil {
+		return 0, util.FmtNewtError("Invalid size: %s", sizeStr)
+	}
+
+	return val * mult, nil
+}
+
+/*
+ * Parses a list of growth limits.  Each entry is either <region>=<size>, or
+ * just <size>; the latter applies to every region without its own limit.
+ * The default limit is stored under the "" key.
+ */
+func ParseGrowthLimits(limitStrs []string) (map[string]uint64, error) {
+	limits := map[string]uint64{}
+
+	for _, limitStr := range limitStrs {
+		region := ""
+		sizeStr := limitStr
+		if strings.Contains(limitStr, "=") {
+			var err error
+			region, sizeStr, err = util.ParseEqualsPair(limitStr)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		size, err := ParseSizeString(sizeStr)
+		if err != nil {
+			return nil, err
+		}
+		limits[region] = size
+	}
+
+	return limits, nil
+}
+
+/*
+ * Reads size data saved by "newt size --format json".
+ */
+func ReadSizeReport(fileName string) (map[string]*PkgSize,
+	map[string]*MemSection, error) {
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, nil, util.NewNewtError(err.Error())
+	}
+
+	report := &SizeReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, nil, util.FmtNewtError("Cannot decode size report %s: "+
+			"%s", fileName, err.Error())
+	}
+
+	pkgSizes := map[string]*PkgSize{}
+	for _, pkgSize := range report.Pkgs {
+		pkgSizes[pkgSize.Name] = pkgSize
+	}
+
+	memSections := map[string]*MemSection{}
+	for _, sec := range report.Sections {
+		memSections[sec.Name] = sec
+	}
+
+	return pkgSizes, memSections, nil
+}
+
+/*
+ * Loads size data from a map file, an elf file (using the map file that newt
+ * generates next to it), or a JSON size report.  Use Builder.loadOtherSizes
+ * to read elf files that have no map file.
+ */
+func LoadSizes(fileName string) (map[string]*PkgSize,
+	map[string]*MemSection, error) {
+
+	switch filepath.Ext(fileName) {
+	case ".json":
+		return ReadSizeReport(fileName)
+	case ".elf":
+		return ParseMapFileSizes(fileName + ".map")
+	default:
+		return ParseMapFileSizes(fileName)
+	}
+}
+
+func sizesDelta(name string, sections []string, oldSizes map[string]uint32,
+	newSizes map[string]uint32) *SizeDelta {
+
+	delta := &SizeDelta{
+		Name:   name,
+		Deltas: map[string]int64{},
+	}
+	for _, sec := range sections {
+		d := int64(newSizes[sec]) - int64(oldSizes[sec])
+		delta.Deltas[sec] = d
+		delta.Total += d
+	}
+
+	return delta
+}
+
+/*
+ * Calculates the per-package and per-region differences between two sets of
+ * size data.  Packages whose size did not change are omitted.
+ */
+func DiffSizes(oldLibs map[string]*PkgSize,
+	oldSects map[string]*MemSection, newLibs map[string]*PkgSize,
+	newSects map[string]*MemSection) *SizeDiff {
+
+	/*
+	 * Order sections by offset; sections that only exist in the old data go
+	 * last.
+	 */
+	sections := []string{}
+	for _, sec := range sortedMemSections(newSects) {
+		sections = append(sections, sec.Name)
+	}
+	for _, sec := range sortedMemSections(oldSects) {
+		if newSects[sec.Name] == nil {
+			sections = append(sections, sec.Name)
+		}
+	}
+
+	diff := &SizeDiff{
+		Sections: sections,
+	}
+
+	oldTotals := map[string]uint32{}
+	newTotals := map[string]uint32{}
+
+	names := map[string]bool{}
+	for name, _ := range oldLibs {
+		names[name] = true
+	}
+	for name, _ := range newLibs {
+		names[name] = true
+	}
+
+	empty := map[string]uint32{}
+	for name, _ := range names {
+		oldSizes := empty
+		if oldLibs[name] != nil {
+			oldSizes = oldLibs[name].Sizes
+		}
+		newSizes := empty
+		if newLibs[name] != nil {
+			newSizes = newLibs[name].Sizes
+		}
+
+		for _, sec := range sections {
+			oldTotals[sec] += oldSizes[sec]
+			newTotals[sec] += newSizes[sec]
+		}
+
+		delta := sizesDelta(name, sections, oldSizes, newSizes)
+		for _, d := range delta.Deltas {
+			if d != 0 {
+				diff.Pkgs = append(diff.Pkgs, delta)
+				break
+			}
+		}
+	}
+	sort.Sort(diff.Pkgs)
+
+	for _, sec := range sections {
+		d := int64(newTotals[sec]) - int64(oldTotals[sec])
+		diff.Regions = append(diff.Regions, &SizeDelta{
+			Name:   sec,
+			Deltas: map[string]int64{sec: d},
+			Total:  d,
+		})
+	}
+	sort.Sort(diff.Regions)
+
+	return diff
+}
+
+/*
+ * Returns an error describing each region whose growth exceeds its limit.
+ */
+func (diff *SizeDiff) CheckGrowth(limits map[string]uint64) error {
+	if len(limits) == 0 {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	for _, region := range diff.Regions {
+		limit, ok := limits[region.Name]
+		if !ok {
+			limit, ok = limits[""]
+		}
+		if !ok {
+			continue
+		}
+
+		if region.Total > 0 && uint64(region.Total) > limit {
+			buffer.WriteString(fmt.Sprintf("Region %s grew by %d bytes; "+
+				"limit is %d bytes\n", region.Name, region.Total, limit))
+		}
+	}
+
+	if buffer.Len() > 0 {
+		return util.NewNewtError("Size growth limit exceeded:\n" +
+			buffer.String())
+	}
+
+	return nil
+}
+
+/*
+ * Return a printable string containing the size differences.
+ */
+func (diff *SizeDiff) String() string {
+	ret := ""
+
+	for _, region := range diff.Regions {
+		ret += fmt.Sprintf("%7s %+7d\n", region.Name, region.Total)
+	}
+	ret += "\n"
+
+	if len(diff.Pkgs) == 0 {
+		ret += "No package size changes\n"
+		return ret
+	}
+
+	for _, sec := range diff.Sections {
+		ret += fmt.Sprintf("%7s ", sec)
+	}
+	ret += "\n"
+	for _, pkgDelta := range diff.Pkgs {
+		for _, sec := range diff.Sections {
+			ret += fmt.Sprintf("%+7d ", pkgDelta.Deltas[sec])
+		}
+		ret += fmt.Sprintf("%s\n", pkgDelta.Name)
+	}
+
+	return ret
+}
+
+/*
+ * Compares the sizes of this target's current build against the sizes stored
+ * in otherFile (map file, elf file, or JSON size report).
+ */
+func (b *Builder) SizeDiff(otherFile string, format string, source string,
+	limitStrs []string) error {
+
+	if b.target.App() == nil {
+		return util.NewNewtError("app package not specified for this target")
+	}
+
+	if format != SIZE_FORMAT_TEXT && format != SIZE_FORMAT_JSON {
+		return util.FmtNewtError("Invalid size output format: %s", format)
+	}
+
+	limits, err := ParseGrowthLimits(limitStrs)
+	if err != nil {
+		return err
+	}
+
+	err = b.PrepBuild()
+	if err != nil {
+		return err
+	}
+
+	oldPkgSizes, oldMemSections, err := b.loadOtherSizes(otherFile)
+	if err != nil {
+		return err
+	}
+
+	newPkgSizes, newMemSections, err := b.loadElfSizes(b.AppElfPath(),
+		source)
+	if err != nil {
+		return err
+	}
+
+	diff := DiffSizes(oldPkgSizes, oldMemSections, newPkgSizes,
+		newMemSections)
+
+	if format == SIZE_FORMAT_JSON {
+		buffer, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return util.NewNewtError(fmt.Sprintf("Cannot encode size "+
+				"diff: %s", err.Error()))
+		}
+		fmt.Printf("%s\n", buffer)
+	} else {
+		fmt.Printf("%s", diff.String())
+	}
+
+	return diff.CheckGrowth(limits)
+}
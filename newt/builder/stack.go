@@ -0,0 +1,508 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+/*
+ * Packages list the entry points of their tasks under this key.
+ */
+const PKG_STACK_ENTRIES = "pkg.stack_entries"
+
+const STACK_DEFAULT_ENTRY = "main"
+
+/*
+ * Stack analysis builds are compiled with stack usage output, so they are kept
+ * apart from the target's normal build; this identifies their bin directory.
+ */
+const STACK_BIN_ID = "stack"
+
+/*
+ * Stack usage and call information for a single function.
+ */
+type StackFunc struct {
+	Name     string
+	Frame    uint64
+	HasFrame bool /* Whether a .su file described this function */
+	Dynamic  bool /* Frame size depends on run-time values */
+	Indirect bool /* Makes calls through function pointers */
+	Callees  []string
+}
+
+/*
+ * Worst-case stack usage of a single entry point.  The depth is a lower bound
+ * if any of the flag lists are non-empty.
+ */
+type StackResult struct {
+	Entry     string   `json:"entry"`
+	Depth     uint64   `json:"depth"`
+	Path      []string `json:"path"`
+	Recursion []string `json:"recursion,omitempty"`
+	Indirect  []string `json:"indirect,omitempty"`
+	Dynamic   []string `json:"dynamic,omitempty"`
+	Unknown   []string `json:"unknown,omitempty"`
+}
+
+func (result *StackResult) Bounded() bool {
+	return len(result.Recursion) == 0 && len(result.Indirect) == 0 &&
+		len(result.Dynamic) == 0 && len(result.Unknown) == 0
+}
+
+func stackFunc(funcs map[string]*StackFunc, name string) *StackFunc {
+	f := funcs[name]
+	if f == nil {
+		f = &StackFunc{Name: name}
+		funcs[name] = f
+	}
+	return f
+}
+
+/*
+ * Parses a stack usage file generated by gcc's -fstack-usage option.  Each
+ * line has the form:
+ *     <file>:<line>:<col>:<function>	<bytes>	<static|dynamic|dynamic,bounded>
+ */
+func ParseStackUsageFile(fileName string, funcs map[string]*StackFunc) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return util.NewNewtError(err.Error())
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 3 {
+			continue
+		}
+
+		loc := fields[0]
+		name := loc[strings.LastIndex(loc, ":")+1:]
+		if name == "" {
+			continue
+		}
+
+		frame, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			return util.FmtNewtError("Invalid stack usage line in %s: %s",
+				fileName, scanner.Text())
+		}
+
+		f := stackFunc(funcs, name)
+		if frame > f.Frame {
+			f.Frame = frame
+		}
+		f.HasFrame = true
+		if strings.HasPrefix(fields[2], "dynamic") &&
+			fields[2] != "dynamic,bounded" {
+
+			f.Dynamic = true
+		}
+	}
+
+	return nil
+}
+
+var objdumpFuncRe = regexp.MustCompile(`^[0-9a-fA-F]+ <([^>]+)>:$`)
+var objdumpTargetRe = regexp.MustCompile(`<([^>+]+)(\+0x[0-9a-fA-F]+)?>`)
+
+/*
+ * Call instructions across the supported architectures.
+ */
+var objdumpCallMnemonics = map[string]bool{
+	"bl":    true,
+	"bl.w":  true,
+	"blx":   true,
+	"call":  true,
+	"callq": true,
+	"calll": true,
+	"jal":   true,
+	"jalr":  true,
+}
+
+/*
+ * Unconditional branches; a branch to the start of another function is a
+ * tail call.
+ */
+var objdumpJumpMnemonics = map[string]bool{
+	"b":    true,
+	"b.n":  true,
+	"b.w":  true,
+	"j":    true,
+	"jmp":  true,
+	"jmpq": true,
+}
+
+func cleanFuncName(name string) string {
+	if i := strings.Index(name, "@"); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+/*
+ * Extracts the call graph from the output of "objdump -d".
+ */
+func ParseDisassembly(text string, funcs map[string]*StackFunc) {
+	var cur *StackFunc
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := objdumpFuncRe.FindStringSubmatch(line); m != nil {
+			cur = stackFunc(funcs, cleanFuncName(m[1]))
+			seen = map[string]bool{}
+			for _, callee := range cur.Callees {
+				seen[callee] = true
+			}
+			continue
+		}
+
+		if cur == nil {
+			continue
+		}
+
+		// <addr>:\t<opcode bytes>\t<mnemonic> <operands>
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		insn := fields[2]
+		if i := strings.IndexAny(insn, "#;"); i >= 0 {
+			insn = insn[:i]
+		}
+		insnFields := strings.Fields(insn)
+		if len(insnFields) == 0 {
+			continue
+		}
+
+		mnemonic := strings.ToLower(insnFields[0])
+		operands := strings.Join(insnFields[1:], " ")
+		isCall := objdumpCallMnemonics[mnemonic]
+		isJump := objdumpJumpMnemonics[mnemonic]
+		if !isCall && !isJump {
+			continue
+		}
+
+		m := objdumpTargetRe.FindStringSubmatch(operands)
+		if m == nil || strings.HasPrefix(operands, "*") {
+			// Branches through registers within a function are usually
+			// jump tables; only calls are treated as indirect.
+			if isCall {
+				cur.Indirect = true
+			}
+			continue
+		}
+
+		callee := cleanFuncName(m[1])
+		if isJump && (m[2] != "" || callee == cur.Name) {
+			// Branch within a function.
+			continue
+		}
+		if !seen[callee] {
+			seen[callee] = true
+			cur.Callees = append(cur.Callees, callee)
+		}
+	}
+}
+
+type stackWalk struct {
+	funcs     map[string]*StackFunc
+	memo      map[string]*StackResult
+	onStack   map[string]bool
+	stack     []string
+	recursion map[string]bool
+	indirect  map[string]bool
+	dynamic   map[string]bool
+	unknown   map[string]bool
+}
+
+func newStackWalk(funcs map[string]*StackFunc) *stackWalk {
+	return &stackWalk{
+		funcs:     funcs,
+		memo:      map[string]*StackResult{},
+		onStack:   map[string]bool{},
+		recursion: map[string]bool{},
+		indirect:  map[string]bool{},
+		dynamic:   map[string]bool{},
+		unknown:   map[string]bool{},
+	}
+}
+
+/*
+ * Calculates the deepest call path below the specified function.  A call back
+ * into a function that is already on the path is recorded as recursion and
+ * contributes nothing to the depth.
+ */
+func (w *stackWalk) visit(name string) *StackResult {
+	if result := w.memo[name]; result != nil {
+		return result
+	}
+
+	if w.onStack[name] {
+		for i, caller := range w.stack {
+			if caller == name {
+				cycle := append(append([]string{}, w.stack[i:]...), name)
+				w.recursion[strings.Join(cycle, " -> ")] = true
+				break
+			}
+		}
+		return &StackResult{}
+	}
+
+	var frame uint64 = 0
+	f := w.funcs[name]
+	if f == nil || !f.HasFrame {
+		w.unknown[name] = true
+	} else {
+		frame = f.Frame
+		if f.Dynamic {
+			w.dynamic[name] = true
+		}
+	}
+
+	var best *StackResult
+	if f != nil {
+		if f.Indirect {
+			w.indirect[name] = true
+		}
+
+		w.onStack[name] = true
+		w.stack = append(w.stack, name)
+		for _, callee := range f.Callees {
+			r := w.visit(callee)
+			if best == nil || r.Depth > best.Depth {
+				best = r
+			}
+		}
+		w.stack = w.stack[:len(w.stack)-1]
+		delete(w.onStack, name)
+	}
+
+	result := &StackResult{
+		Depth: frame,
+		Path:  []string{name},
+	}
+	if best != nil {
+		result.Depth += best.Depth
+		result.Path = append(result.Path, best.Path...)
+	}
+
+	w.memo[name] = result
+	return result
+}
+
+func sortedStrings(m map[string]bool) []string {
+	strs := make([]string, 0, len(m))
+	for s, _ := range m {
+		strs = append(strs, s)
+	}
+	sort.Strings(strs)
+	return strs
+}
+
+/*
+ * Calculates the worst-case stack depth of the specified entry point.
+ */
+func AnalyzeStack(funcs map[string]*StackFunc, entry string) *StackResult {
+	w := newStackWalk(funcs)
+	r := w.visit(entry)
+
+	return &StackResult{
+		Entry:     entry,
+		Depth:     r.Depth,
+		Path:      r.Path,
+		Recursion: sortedStrings(w.recursion),
+		Indirect:  sortedStrings(w.indirect),
+		Dynamic:   sortedStrings(w.dynamic),
+		Unknown:   sortedStrings(w.unknown),
+	}
+}
+
+/*
+ * Return a printable string describing the stack usage of each entry point.
+ */
+func PrintStackResults(results []*StackResult,
+	funcs map[string]*StackFunc) string {
+
+	ret := ""
+	for _, r := range results {
+		bound := ""
+		if !r.Bounded() {
+			bound = " (lower bound)"
+		}
+		ret += fmt.Sprintf("%s: %d bytes%s\n", r.Entry, r.Depth, bound)
+
+		for i, name := range r.Path {
+			frame := "?"
+			if f := funcs[name]; f != nil && f.HasFrame {
+				frame = strconv.FormatUint(f.Frame, 10)
+			}
+			ret += fmt.Sprintf("    %*s%s (%s)\n", i*2, "", name, frame)
+		}
+
+		if len(r.Recursion) > 0 {
+			ret += "    Recursion:\n"
+			for _, cycle := range r.Recursion {
+				ret += "        " + cycle + "\n"
+			}
+		}
+		if len(r.Indirect) > 0 {
+			ret += "    Indirect calls in: " +
+				strings.Join(r.Indirect, ", ") + "\n"
+		}
+		if len(r.Dynamic) > 0 {
+			ret += "    Dynamic stack usage in: " +
+				strings.Join(r.Dynamic, ", ") + "\n"
+		}
+		if len(r.Unknown) > 0 {
+			ret += "    No stack usage information for: " +
+				strings.Join(r.Unknown, ", ") + "\n"
+		}
+		ret += "\n"
+	}
+
+	return ret
+}
+
+/*
+ * Collects the entry points to analyze: those specified on the command line,
+ * plus those listed by the packages in the build.  If there are none, main
+ * is analyzed.
+ */
+func (b *Builder) stackEntries(entries []string) []string {
+	all := map[string]bool{}
+	for _, entry := range entries {
+		all[entry] = true
+	}
+
+	for _, bpkg := range b.sortedBuildPackages() {
+		for _, entry := range newtutil.GetStringSliceFeatures(bpkg.Viper,
+			b.Features(), PKG_STACK_ENTRIES) {
+
+			all[entry] = true
+		}
+	}
+
+	if len(all) == 0 {
+		all[STACK_DEFAULT_ENTRY] = true
+	}
+
+	return sortedStrings(all)
+}
+
+/*
+ * Reads all the stack usage files generated for this target.
+ */
+func (b *Builder) stackUsageFuncs() (map[string]*StackFunc, error) {
+	funcs := map[string]*StackFunc{}
+
+	err := filepath.Walk(b.BinDir(),
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || filepath.Ext(path) != ".su" {
+				return nil
+			}
+			return ParseStackUsageFile(path, funcs)
+		})
+	if err != nil {
+		return nil, util.NewNewtError(err.Error())
+	}
+
+	return funcs, nil
+}
+
+/*
+ * Builds the target with stack usage output enabled, and reports the
+ * worst-case stack depth of each entry point.  Recursion, calls through
+ * function pointers, dynamically sized frames, and functions without stack
+ * usage information (e.g., assembly or library code) make the reported depth
+ * a lower bound; these are listed for each entry point.
+ */
+func (b *Builder) Stack(entries []string, format string) error {
+	if b.target.App() == nil {
+		return util.NewNewtError("app package not specified for this target")
+	}
+
+	if format != SIZE_FORMAT_TEXT && format != SIZE_FORMAT_JSON {
+		return util.FmtNewtError("Invalid stack output format: %s", format)
+	}
+
+	b.stackUsage = true
+	if err := b.Build(); err != nil {
+		return err
+	}
+
+	funcs, err := b.stackUsageFuncs()
+	if err != nil {
+		return err
+	}
+
+	c, err := b.newCompiler(nil, b.BinDir())
+	if err != nil {
+		return err
+	}
+
+	disasm, err := c.Disassemble(b.AppElfPath())
+	if err != nil {
+		return err
+	}
+	ParseDisassembly(disasm, funcs)
+
+	results := []*StackResult{}
+	for _, entry := range b.stackEntries(entries) {
+		if funcs[entry] == nil {
+			return util.FmtNewtError("Stack entry point not found in "+
+				"image: %s", entry)
+		}
+		results = append(results, AnalyzeStack(funcs, entry))
+	}
+
+	if format == SIZE_FORMAT_JSON {
+		buffer, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return util.NewNewtError(fmt.Sprintf("Cannot encode stack "+
+				"report: %s", err.Error()))
+		}
+		fmt.Printf("%s\n", buffer)
+	} else {
+		fmt.Printf("%s", PrintStackResults(results, funcs))
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/syscfg"
+)
+
+// Resolves the system configuration of the packages in the build and writes
+// syscfg.h.  This must be done after the full set of packages is known.
+func (b *Builder) generateSyscfg() error {
+	lpkgs := make([]*pkg.LocalPackage, 0, len(b.Packages))
+	for _, bpkg := range b.sortedBuildPackages() {
+		lpkgs = append(lpkgs, bpkg.LocalPackage)
+	}
+
+	cfg, err := syscfg.Read(lpkgs)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range cfg.SortedSettings() {
+		log.Debugf("Setting %s=%s (%s)", s.Name, s.Value, s.SetBy)
+	}
+
+	if err := cfg.WriteHeader(b.GeneratedIncludeDir()); err != nil {
+		return err
+	}
+
+	b.syscfg = cfg
+	return nil
+}
+
+// Returns the build's system configuration; nil if the build hasn't been
+// prepared.
+func (b *Builder) Syscfg() *syscfg.Cfg {
+	return b.syscfg
+}
+
+// Returns the path of the build's syscfg.h.
+func (b *Builder) SyscfgHeaderPath() string {
+	return syscfg.HeaderPath(b.GeneratedIncludeDir())
+}
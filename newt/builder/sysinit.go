@@ -0,0 +1,277 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+// Maps a package's init functions to their stages (e.g., pkg.init:
+// {log_init: 100}).  Lower stages run first.  The functions must be listed
+// in a map: viper lowercases flattened keys (pkg.init.log_init), which would
+// change the function names.
+const PKG_INIT = "pkg.init"
+
+// The generated function which calls every package's init functions.  An
+// app's main() calls it after including <sysinit/sysinit.h>.
+const SYSINIT_FN = "sysinit"
+
+const SYSINIT_SRC_FILENAME = "sysinit.c"
+const SYSINIT_HEADER_SUBDIR = "sysinit"
+const SYSINIT_HEADER_FILENAME = "sysinit.h"
+
+var cIdentRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type initFunc struct {
+	name    string
+	stage   int
+	pkgName string
+
+	// Position of the package in dependency order; breaks ties between
+	// functions in the same stage, so that a package is initialized after
+	// the packages it depends on.
+	depIdx int
+}
+
+type initFuncSorter []*initFunc
+
+func (s initFuncSorter) Len() int {
+	return len(s)
+}
+
+func (s initFuncSorter) Less(i, j int) bool {
+	switch {
+	case s[i].stage != s[j].stage:
+		return s[i].stage < s[j].stage
+	case s[i].depIdx != s[j].depIdx:
+		return s[i].depIdx < s[j].depIdx
+	default:
+		return s[i].name < s[j].name
+	}
+}
+
+func (s initFuncSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Appends the package to the specified list after its dependencies, unless
+// it has already been visited.
+func (bpkg *BuildPackage) collectDepOrder(b *Builder,
+	visited map[*BuildPackage]bool, order *[]*BuildPackage) error {
+
+	if visited[bpkg] {
+		return nil
+	}
+	visited[bpkg] = true
+
+	for _, dep := range bpkg.Deps() {
+		dpkg, ok := project.GetProject().ResolveDependency(dep).(*pkg.LocalPackage)
+		if !ok || dpkg == nil {
+			return util.NewNewtError("Cannot resolve dependency " +
+				dep.String())
+		}
+
+		dbpkg := b.Packages[dpkg]
+		if dbpkg == nil {
+			return util.FmtNewtError("Package not found (%s)", dpkg.Name())
+		}
+
+		if err := dbpkg.collectDepOrder(b, visited, order); err != nil {
+			return err
+		}
+	}
+
+	*order = append(*order, bpkg)
+	return nil
+}
+
+// Returns the packages in the build such that each package follows its
+// dependencies.  Unrelated packages are ordered by name.
+func (b *Builder) depOrderedPackages() ([]*BuildPackage, error) {
+	visited := map[*BuildPackage]bool{}
+	order := []*BuildPackage{}
+
+	for _, bpkg := range b.sortedBuildPackages() {
+		if err := bpkg.collectDepOrder(b, visited, &order); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// Collects the init functions of the packages in the build, in the order
+// they are to be called.
+func (b *Builder) initFuncs() ([]*initFunc, error) {
+	bpkgs, err := b.depOrderedPackages()
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := []*initFunc{}
+	owners := map[string]string{}
+	for i, bpkg := range bpkgs {
+		for _, k := range bpkg.Viper.AllKeys() {
+			if strings.HasPrefix(k, PKG_INIT+".") {
+				return nil, util.FmtNewtError("Package %s: invalid "+
+					"setting %s; init functions must be listed in a map "+
+					"under %s, as a flattened key loses the case of the "+
+					"function name", bpkg.Name(), k, PKG_INIT)
+			}
+		}
+
+		for name, stageVal := range bpkg.Viper.GetStringMap(PKG_INIT) {
+			if !cIdentRe.MatchString(name) {
+				return nil, util.FmtNewtError("Package %s: invalid init "+
+					"function name: \"%s\"", bpkg.Name(), name)
+			}
+
+			stageStr := strings.TrimSpace(fmt.Sprint(stageVal))
+			stage, err := strconv.Atoi(stageStr)
+			if err != nil {
+				return nil, util.FmtNewtError("Package %s: invalid stage "+
+					"for init function %s: \"%s\"", bpkg.Name(), name,
+					stageStr)
+			}
+
+			if owner, ok := owners[name]; ok {
+				return nil, util.FmtNewtError("Init function %s is "+
+					"declared by both %s and %s", name, owner, bpkg.Name())
+			}
+			owners[name] = bpkg.Name()
+
+			funcs = append(funcs, &initFunc{
+				name:    name,
+				stage:   stage,
+				pkgName: bpkg.Name(),
+				depIdx:  i,
+			})
+		}
+	}
+
+	sort.Sort(initFuncSorter(funcs))
+	return funcs, nil
+}
+
+func sysinitSrcText(funcs []*initFunc) string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("/**\n")
+	buffer.WriteString(" * This file was generated by " +
+		newtutil.NewtVersionStr + "\n")
+	buffer.WriteString(" */\n\n")
+	buffer.WriteString("#include \"" + SYSINIT_HEADER_SUBDIR + "/" +
+		SYSINIT_HEADER_FILENAME + "\"\n\n")
+
+	for _, f := range funcs {
+		buffer.WriteString("void " + f.name + "(void);\n")
+	}
+	if len(funcs) > 0 {
+		buffer.WriteString("\n")
+	}
+
+	buffer.WriteString("void\n" + SYSINIT_FN + "(void)\n{\n")
+	for i, f := range funcs {
+		if i == 0 || f.stage != funcs[i-1].stage {
+			if i != 0 {
+				buffer.WriteString("\n")
+			}
+			buffer.WriteString(fmt.Sprintf("    /*** Stage %d */\n",
+				f.stage))
+		}
+		buffer.WriteString(fmt.Sprintf("    /* %s */\n", f.pkgName))
+		buffer.WriteString("    " + f.name + "();\n")
+	}
+	buffer.WriteString("}\n")
+
+	return buffer.String()
+}
+
+func sysinitHeaderText() string {
+	return "/**\n" +
+		" * This file was generated by " + newtutil.NewtVersionStr + "\n" +
+		" */\n\n" +
+		"#ifndef H_MYNEWT_SYSINIT_\n" +
+		"#define H_MYNEWT_SYSINIT_\n\n" +
+		"/* Calls the init function of each package in the build. */\n" +
+		"void " + SYSINIT_FN + "(void);\n\n" +
+		"#endif\n"
+}
+
+func (b *Builder) SysinitSrcDir() string {
+	return b.GeneratedDir() + "/src"
+}
+
+// Generates the header which declares sysinit().  This is done along with
+// syscfg.h, when the build is prepared, so that any package can include it.
+func (b *Builder) generateSysinitHeader() error {
+	return newtutil.WriteFileIfChanged(b.GeneratedIncludeDir()+"/"+
+		SYSINIT_HEADER_SUBDIR+"/"+SYSINIT_HEADER_FILENAME,
+		[]byte(sysinitHeaderText()))
+}
+
+// Generates and compiles the sysinit source, so that an app's main() needn't
+// call each package's init function itself.
+//
+// @return []string             The object files to link into the image.
+func (b *Builder) buildSysinit() ([]string, error) {
+	funcs, err := b.initFuncs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range funcs {
+		util.StatusMessage(util.VERBOSITY_VERBOSE,
+			"Init function %s (stage %d, %s)\n", f.name, f.stage, f.pkgName)
+	}
+
+	srcDir := b.SysinitSrcDir()
+	if err := newtutil.WriteFileIfChanged(srcDir+"/"+SYSINIT_SRC_FILENAME,
+		[]byte(sysinitSrcText(funcs))); err != nil {
+
+		return nil, err
+	}
+
+	c, err := b.newCompiler(nil, b.GeneratedDir())
+	if err != nil {
+		return nil, err
+	}
+	if err := buildDir(srcDir, c, b.Bsp.Arch, nil); err != nil {
+		return nil, err
+	}
+
+	objFiles := []string{}
+	for objFile, _ := range c.ObjPathList {
+		objFiles = append(objFiles, objFile)
+	}
+	sort.Strings(objFiles)
+
+	return objFiles, nil
+}
@@ -22,6 +22,7 @@ package cli
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"mynewt.apache.org/newt/newt/builder"
@@ -33,10 +34,80 @@ import (
 
 const TARGET_TEST_NAME = "unittest"
 
+var sizeSymbols bool = false
+var sizeFormat string = builder.SIZE_FORMAT_TEXT
+var sizeDiffFile string = ""
+var sizeMaxGrowth []string
+var sizeSource string = builder.SIZE_SOURCE_AUTO
+var stackEntries []string
+var stackFormat string = builder.SIZE_FORMAT_TEXT
+
+// Settings applied to a single build on top of the target's.
+var buildFeatures []string
+var buildCflags []string
+var buildProfile string
+
 func pkgIsTestable(pack *pkg.LocalPackage) bool {
 	return util.NodeExist(pack.BasePath() + "/src/test")
 }
 
+// Creates a builder for the target, applying any settings specified with
+// --feature, --cflag, and --profile.
+func newOverriddenBuilder(t *target.Target) (*builder.Builder, error) {
+	b, err := builder.NewBuilder(t)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := &builder.BuildOverrides{
+		Features: buildFeatures,
+		Cflags:   buildCflags,
+		Profile:  buildProfile,
+	}
+	if err := b.SetOverrides(overrides); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Appended to the help text of commands which operate on an existing build.
+const buildOverrideHelpText = "  To use a build made with --feature, " +
+	"--cflag, or --profile, specify the same settings."
+
+// A flag which can be repeated.  Unlike a string slice flag, each value is
+// kept as is rather than split as CSV, since compiler flags can contain
+// commas and quotes (e.g., -Wl,--gc-sections).
+type repeatedStringFlag struct {
+	values *[]string
+}
+
+func (f *repeatedStringFlag) String() string {
+	if len(*f.values) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(*f.values, " ") + "]"
+}
+
+func (f *repeatedStringFlag) Set(value string) error {
+	*f.values = append(*f.values, value)
+	return nil
+}
+
+func (f *repeatedStringFlag) Type() string {
+	return "string"
+}
+
+func addBuildOverrideFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().VarP(&repeatedStringFlag{&buildFeatures},
+		"feature", "", "Enable a feature for this build only; may be "+
+			"repeated")
+	cmd.PersistentFlags().VarP(&repeatedStringFlag{&buildCflags}, "cflag",
+		"", "Add a compiler flag for this build only; may be repeated")
+	cmd.PersistentFlags().StringVarP(&buildProfile, "profile", "", "",
+		"Use a build profile for this build only")
+}
+
 func buildRunCmd(cmd *cobra.Command, args []string) {
 	if err := project.Initialize(); err != nil {
 		NewtUsage(cmd, err)
@@ -65,14 +136,19 @@ func buildRunCmd(cmd *cobra.Command, args []string) {
 				targetName))
 		}
 
-		util.StatusMessage(util.VERBOSITY_DEFAULT, "Building target %s\n",
-			t.FullName())
-
-		b, err := builder.NewBuilder(t)
+		b, err := newOverriddenBuilder(t)
 		if err != nil {
 			NewtUsage(nil, err)
 		}
 
+		if o := b.Overrides(); o != nil {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"Building target %s (%s)\n", t.FullName(), o.String())
+		} else {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"Building target %s\n", t.FullName())
+		}
+
 		err = b.Build()
 		if err != nil {
 			NewtUsage(nil, err)
@@ -241,7 +317,7 @@ func loadRunCmd(cmd *cobra.Command, args []string) {
 		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
 	}
 
-	b, err := builder.NewBuilder(t)
+	b, err := newOverriddenBuilder(t)
 	if err != nil {
 		NewtUsage(cmd, err)
 	}
@@ -265,7 +341,7 @@ func debugRunCmd(cmd *cobra.Command, args []string) {
 		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
 	}
 
-	b, err := builder.NewBuilder(t)
+	b, err := newOverriddenBuilder(t)
 	if err != nil {
 		NewtUsage(cmd, err)
 	}
@@ -289,23 +365,63 @@ func sizeRunCmd(cmd *cobra.Command, args []string) {
 		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
 	}
 
-	b, err := builder.NewBuilder(t)
+	b, err := newOverriddenBuilder(t)
 	if err != nil {
 		NewtUsage(cmd, err)
 	}
 
-	err = b.Size()
+	if sizeDiffFile != "" {
+		err = b.SizeDiff(sizeDiffFile, sizeFormat, sizeSource,
+			sizeMaxGrowth)
+	} else {
+		err = b.Size(sizeSymbols, sizeFormat, sizeSource)
+	}
 	if err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
+func stackRunCmd(cmd *cobra.Command, args []string) {
+	if err := project.Initialize(); err != nil {
+		NewtUsage(cmd, err)
+	}
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	t := ResolveTarget(args[0])
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	b, err := newOverriddenBuilder(t)
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	if err := b.Stack(stackEntries, stackFormat); err != nil {
 		NewtUsage(cmd, err)
 	}
 }
 
 func AddBuildCommands(cmd *cobra.Command) {
+	buildHelpText := "Build one or more targets.  Features, compiler " +
+		"flags, and a build profile can be specified for a single build " +
+		"with --feature, --cflag, and --profile, without changing the " +
+		"target.  Such a build is kept in its own bin directory, so that " +
+		"it doesn't clobber the target's normal build."
+	buildHelpEx := "  newt build my_target1\n"
+	buildHelpEx += "  newt build my_target1 --feature FOO --cflag -DX=1 " +
+		"--profile debug"
+
 	buildCmd := &cobra.Command{
-		Use:   "build <target-name> [target-names...]",
-		Short: "Builds one or more targets.",
-		Run:   buildRunCmd,
+		Use:     "build <target-name> [target-names...]",
+		Short:   "Builds one or more targets.",
+		Long:    buildHelpText,
+		Example: buildHelpEx,
+		Run:     buildRunCmd,
 	}
+	addBuildOverrideFlags(buildCmd)
 
 	cmd.AddCommand(buildCmd)
 
@@ -330,9 +446,10 @@ func AddBuildCommands(cmd *cobra.Command) {
 	loadCmd := &cobra.Command{
 		Use:   "load <target-name>",
 		Short: "Load built target to board",
-		Long:  loadHelpText,
+		Long:  loadHelpText + buildOverrideHelpText,
 		Run:   loadRunCmd,
 	}
+	addBuildOverrideFlags(loadCmd)
 	cmd.AddCommand(loadCmd)
 
 	debugHelpText := "Open debugger session for <target-name>."
@@ -340,19 +457,62 @@ func AddBuildCommands(cmd *cobra.Command) {
 	debugCmd := &cobra.Command{
 		Use:   "debug <target-name>",
 		Short: "Open debugger session to target",
-		Long:  debugHelpText,
+		Long:  debugHelpText + buildOverrideHelpText,
 		Run:   debugRunCmd,
 	}
+	addBuildOverrideFlags(debugCmd)
 	cmd.AddCommand(debugCmd)
 
 	sizeHelpText := "Calculate the size of target components specified by " +
 		"<target-name>."
+	sizeHelpEx := "  newt size <target-name>\n"
+	sizeHelpEx += "  newt size --symbols my_target1\n"
+	sizeHelpEx += "  newt size --format json my_target1\n"
+	sizeHelpEx += "  newt size --diff old.json my_target1\n"
+	sizeHelpEx += "  newt size --diff old.elf --max-growth FLASH=1k my_target1"
 
 	sizeCmd := &cobra.Command{
-		Use:   "size <target-name>",
-		Short: "Size of target components",
-		Long:  sizeHelpText,
-		Run:   sizeRunCmd,
-	}
+		Use:     "size <target-name>",
+		Short:   "Size of target components",
+		Long:    sizeHelpText + buildOverrideHelpText,
+		Example: sizeHelpEx,
+		Run:     sizeRunCmd,
+	}
+	sizeCmd.PersistentFlags().BoolVarP(&sizeSymbols, "symbols", "", false,
+		"Break down each package by object file and symbol")
+	sizeCmd.PersistentFlags().StringVarP(&sizeFormat, "format", "",
+		builder.SIZE_FORMAT_TEXT, "Output format (text or json)")
+	sizeCmd.PersistentFlags().StringVarP(&sizeDiffFile, "diff", "", "",
+		"Compare against sizes from a map file, elf file, or JSON report")
+	sizeCmd.PersistentFlags().StringSliceVarP(&sizeMaxGrowth, "max-growth",
+		"", nil, "Fail if a region grows by more than [<region>=]<size> "+
+			"bytes when used with --diff")
+	sizeCmd.PersistentFlags().StringVarP(&sizeSource, "source", "", "",
+		"Read sizes from the map file (map) or directly from the elf file "+
+			"(elf); default is map, or elf for sim targets")
+	addBuildOverrideFlags(sizeCmd)
 	cmd.AddCommand(sizeCmd)
+
+	stackHelpText := "Build <target-name> and report the worst-case stack " +
+		"depth of each entry point.  Entry points are specified with " +
+		"--entry or listed by packages under pkg.stack_entries; the default " +
+		"is main.  Recursion, indirect calls, and functions without stack " +
+		"usage information are reported as unknown."
+	stackHelpEx := "  newt stack <target-name>\n"
+	stackHelpEx += "  newt stack --entry os_idle_task --entry main my_target1\n"
+	stackHelpEx += "  newt stack --format json my_target1"
+
+	stackCmd := &cobra.Command{
+		Use:     "stack <target-name>",
+		Short:   "Worst-case stack usage of target entry points",
+		Long:    stackHelpText + buildOverrideHelpText,
+		Example: stackHelpEx,
+		Run:     stackRunCmd,
+	}
+	stackCmd.PersistentFlags().StringSliceVarP(&stackEntries, "entry", "",
+		nil, "Function to report the stack depth of")
+	stackCmd.PersistentFlags().StringVarP(&stackFormat, "format", "",
+		builder.SIZE_FORMAT_TEXT, "Output format (text or json)")
+	addBuildOverrideFlags(stackCmd)
+	cmd.AddCommand(stackCmd)
 }
@@ -23,7 +23,6 @@ import (
 	"strconv"
 
 	"github.com/spf13/cobra"
-	"mynewt.apache.org/newt/newt/builder"
 	"mynewt.apache.org/newt/newt/image"
 	"mynewt.apache.org/newt/newt/project"
 	"mynewt.apache.org/newt/util"
@@ -43,7 +42,7 @@ func createImageRunCmd(cmd *cobra.Command, args []string) {
 		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+targetName))
 	}
 
-	b, err := builder.NewBuilder(t)
+	b, err := newOverriddenBuilder(t)
 	if err != nil {
 		NewtUsage(cmd, err)
 		return
@@ -113,5 +112,6 @@ func AddImageCommands(cmd *cobra.Command) {
 		Example: createImageHelpEx,
 		Run:     createImageRunCmd,
 	}
+	addBuildOverrideFlags(createImageCmd)
 	cmd.AddCommand(createImageCmd)
 }
@@ -0,0 +1,89 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+func lintRunCmd(cmd *cobra.Command, args []string) {
+	if err := project.Initialize(); err != nil {
+		NewtUsage(cmd, err)
+	}
+	proj := project.GetProject()
+	interfaces.SetProject(proj)
+
+	lpkgs := []*pkg.LocalPackage{}
+	for _, arg := range args {
+		pack, err := ResolvePackage(arg)
+		if err != nil {
+			NewtUsage(cmd, err)
+		}
+		lpkgs = append(lpkgs, pack)
+	}
+
+	problems, err := proj.Lint(lpkgs)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	projPath := proj.Path() + "/"
+	for _, p := range problems {
+		p.File = strings.TrimPrefix(p.File, projPath)
+		util.StatusMessage(util.VERBOSITY_QUIET, "%s\n", p.String())
+	}
+
+	if len(problems) > 0 {
+		NewtUsage(nil, util.FmtNewtError("%d problem(s) found",
+			len(problems)))
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "No problems found\n")
+}
+
+func AddLintCommands(cmd *cobra.Command) {
+	lintHelpText := "Check project.yml, and the pkg.yml, target.yml, and " +
+		"compiler.yml files of the specified packages, for unknown " +
+		"settings, values of the wrong type, invalid package types, " +
+		"references to packages that don't exist, and settings " +
+		"conditioned on features that no package declares.  If no " +
+		"packages are specified, the packages in the local repository and " +
+		"in repositories used in place are checked.  Each problem is " +
+		"reported with its file and line number."
+	lintHelpEx := "  newt lint\n"
+	lintHelpEx += "  newt lint apps/blinky targets/my_blinky_sim"
+
+	lintCmd := &cobra.Command{
+		Use:     "lint [package...]",
+		Short:   "Check a project's YAML files for errors",
+		Long:    lintHelpText,
+		Example: lintHelpEx,
+		Run:     lintRunCmd,
+	}
+
+	cmd.AddCommand(lintCmd)
+}
@@ -0,0 +1,229 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
+)
+
+var pkgMoveDryRun bool = false
+
+func pkgNewRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		NewtUsage(cmd, util.NewNewtError("Must specify a package type "+
+			"and a package path"))
+	}
+
+	if err := project.Initialize(); err != nil {
+		NewtUsage(cmd, err)
+	}
+	proj := project.GetProject()
+	interfaces.SetProject(proj)
+
+	pkgType, err := pkg.PackageTypeFromString(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	repoName, pkgName, err := newtutil.ParsePackageString(
+		strings.TrimSuffix(args[1], "/"))
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+	if repoName != "" {
+		NewtUsage(cmd, util.NewNewtError("Package name cannot contain "+
+			"repo; must be local"))
+	}
+
+	pkgName = filepath.ToSlash(filepath.Clean(pkgName))
+	if filepath.IsAbs(pkgName) || strings.HasPrefix(pkgName, "..") ||
+		pkgName == "." {
+
+		NewtUsage(cmd, util.NewNewtError("Package path must be relative "+
+			"to the project base directory: "+args[1]))
+	}
+
+	dep := &pkg.Dependency{Name: pkgName, Repo: repo.REPO_NAME_LOCAL}
+	if proj.ResolveDependency(dep) != nil {
+		NewtUsage(cmd, util.NewNewtError("Package already exists: "+
+			pkgName))
+	}
+
+	paths, err := pkg.CreatePackage(proj.LocalRepo(), pkgName, pkgType)
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	for _, path := range paths {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "Created %s\n", path)
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Package %s successfully created\n", pkgName)
+}
+
+func pkgMoveRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		NewtUsage(cmd, util.NewNewtError("Must specify a package to move "+
+			"and its new name"))
+	}
+
+	if err := project.Initialize(); err != nil {
+		NewtUsage(cmd, err)
+	}
+	proj := project.GetProject()
+	interfaces.SetProject(proj)
+
+	pack, err := ResolvePackage(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	repoName, newName, err := newtutil.ParsePackageString(
+		strings.TrimSuffix(args[1], "/"))
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+	if repoName != "" && repoName != pack.Repo().Name() {
+		NewtUsage(cmd, util.NewNewtError("Cannot move a package to "+
+			"another repository"))
+	}
+
+	newName = filepath.ToSlash(filepath.Clean(newName))
+	if filepath.IsAbs(newName) || strings.HasPrefix(newName, "..") ||
+		newName == "." {
+
+		NewtUsage(cmd, util.NewNewtError("New package name must be "+
+			"relative to the repository base directory: "+args[1]))
+	}
+
+	pm, err := pkg.PlanPackageMove(pack, newName)
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	projPath := proj.Path() + "/"
+	verb := "Moving"
+	if pkgMoveDryRun {
+		verb = "Would move"
+	}
+	oldNames := []string{}
+	for oldName, _ := range pm.Renames {
+		oldNames = append(oldNames, oldName)
+	}
+	sort.Strings(oldNames)
+	for _, oldName := range oldNames {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s %s to %s\n", verb,
+			oldName, pm.Renames[oldName])
+	}
+
+	verb = "Updating"
+	if pkgMoveDryRun {
+		verb = "Would update"
+	}
+	for _, f := range pm.Files {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s %s\n", verb,
+			strings.TrimPrefix(f.NewPath, projPath))
+	}
+
+	if pkgMoveDryRun {
+		return
+	}
+
+	if err := pm.Apply(); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Package %s successfully moved to %s\n", pm.OldName, pm.NewName)
+}
+
+func AddPackageCommands(cmd *cobra.Command) {
+	pkgHelpText := "Commands for creating and manipulating packages."
+	pkgHelpEx := "  newt pkg new lib libs/mylib"
+
+	pkgCmd := &cobra.Command{
+		Use:     "pkg",
+		Short:   "Create and manage packages",
+		Long:    pkgHelpText,
+		Example: pkgHelpEx,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Usage()
+		},
+	}
+
+	cmd.AddCommand(pkgCmd)
+
+	newHelpText := "Create a package of the specified type (" +
+		strings.Join(pkg.ScaffoldTypeNames(), ", ") + ") at the " +
+		"specified path, relative to the project base directory.  The " +
+		"package is generated from the template for its type; a project " +
+		"can provide its own template for a type in the " +
+		pkg.PACKAGE_TEMPLATE_DIR + "/<type>/ directory.  Occurrences of " +
+		"{{pkg.name}}, {{pkg.basename}}, {{pkg.type}}, {{pkg.ident}}, and " +
+		"{{pkg.IDENT}} in a template's file names and contents are " +
+		"replaced with the package's name, the last component of its name, " +
+		"its type, and its name as a C identifier in lower and upper case."
+	newHelpEx := "  newt pkg new lib libs/mylib\n"
+	newHelpEx += "  newt pkg new app apps/myapp\n"
+	newHelpEx += "  newt pkg new bsp hw/bsp/myboard\n"
+	newHelpEx += "  newt pkg new compiler compiler/mycompiler"
+
+	newCmd := &cobra.Command{
+		Use:     "new <type> <path>",
+		Short:   "Create a new package",
+		Long:    newHelpText,
+		Example: newHelpEx,
+		Run:     pkgNewRunCmd,
+	}
+
+	pkgCmd.AddCommand(newCmd)
+
+	moveHelpText := "Move a package in the local repository, or in a " +
+		"repository used in place, to a new location.  Packages in the " +
+		"package's directory are moved with it.  Each reference to a " +
+		"moved package in the pkg.yml and target.yml files of the local " +
+		"repository and of the package's repository is rewritten, " +
+		"including the package's own pkg.name."
+	moveHelpEx := "  newt pkg move libs/mylib libs/util/mylib\n"
+	moveHelpEx += "  newt pkg move -n apps/old apps/new"
+
+	moveCmd := &cobra.Command{
+		Use:     "move <old-name> <new-name>",
+		Short:   "Move or rename a package",
+		Long:    moveHelpText,
+		Example: moveHelpEx,
+		Run:     pkgMoveRunCmd,
+	}
+	moveCmd.PersistentFlags().BoolVarP(&pkgMoveDryRun, "dry-run", "n", false,
+		"Show what would change without moving anything")
+
+	pkgCmd.AddCommand(moveCmd)
+}
@@ -27,12 +27,15 @@ import (
 	"github.com/spf13/cobra"
 	"mynewt.apache.org/newt/newt/downloader"
 	"mynewt.apache.org/newt/newt/interfaces"
-	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/newt/project"
 	"mynewt.apache.org/newt/util"
 )
 
 var projectForce bool = false
+var projectFrozen bool = false
+var projectOffline bool = false
+var newTemplate string
+var newBsp string
 
 func newRunCmd(cmd *cobra.Command, args []string) {
 	if len(args) < 1 {
@@ -47,25 +50,13 @@ func newRunCmd(cmd *cobra.Command, args []string) {
 			"directory already exists"))
 	}
 
-	util.StatusMessage(util.VERBOSITY_DEFAULT, "Downloading "+
-		"project skeleton from apache/incubator-mynewt-blinky...\n")
-	dl := downloader.NewGithubDownloader()
-	dl.User = "apache"
-	dl.Repo = "incubator-mynewt-blinky"
-
-	dir, err := dl.DownloadRepo(newtutil.NewtBlinkyTag)
+	tmpl, err := project.FindTemplate(newTemplate)
 	if err != nil {
 		NewtUsage(cmd, err)
 	}
 
-	util.StatusMessage(util.VERBOSITY_DEFAULT, "Installing "+
-		"skeleton in %s...\n", newDir)
-
-	if err := util.CopyDir(dir, newDir); err != nil {
-		NewtUsage(cmd, err)
-	}
-
-	if err := os.RemoveAll(newDir + "/" + "/.git/"); err != nil {
+	if err := tmpl.CreateProject(newDir, newBsp); err != nil {
+		os.RemoveAll(newDir)
 		NewtUsage(cmd, err)
 	}
 
@@ -80,7 +71,8 @@ func installRunCmd(cmd *cobra.Command, args []string) {
 	proj := project.GetProject()
 	interfaces.SetProject(proj)
 
-	if err := proj.Install(false, projectForce); err != nil {
+	downloader.Offline = projectOffline
+	if err := proj.Install(false, projectForce, projectFrozen); err != nil {
 		NewtUsage(cmd, err)
 	}
 }
@@ -92,6 +84,7 @@ func upgradeRunCmd(cmd *cobra.Command, args []string) {
 	proj := project.GetProject()
 	interfaces.SetProject(proj)
 
+	downloader.Offline = projectOffline
 	if err := proj.Upgrade(projectForce); err != nil {
 		NewtUsage(cmd, err)
 	}
@@ -171,6 +164,11 @@ func AddProjectCommands(cmd *cobra.Command) {
 	installCmd.PersistentFlags().BoolVarP(&projectForce, "force", "f", false,
 		"Force install of the repositories in project, regardless of what "+
 			"exists in repos directory")
+	installCmd.PersistentFlags().BoolVarP(&projectFrozen, "frozen", "", false,
+		"Fail if project.lock does not match the repositories required by "+
+			"project.yml")
+	installCmd.PersistentFlags().BoolVarP(&projectOffline, "offline", "",
+		false, "Install from the repo cache without network access")
 
 	cmd.AddCommand(installCmd)
 
@@ -184,12 +182,26 @@ func AddProjectCommands(cmd *cobra.Command) {
 		Run:     upgradeRunCmd,
 	}
 	upgradeCmd.PersistentFlags().BoolVarP(&projectForce, "force", "f", false,
-		"Force upgrade of the repositories to latest state in project.yml")
+		"Force upgrade of the repositories to latest state in project.yml, "+
+			"discarding local modifications")
+	upgradeCmd.PersistentFlags().BoolVarP(&projectOffline, "offline", "",
+		false, "Upgrade from the repo cache without network access")
 
 	cmd.AddCommand(upgradeCmd)
 
-	newHelpText := ""
-	newHelpEx := ""
+	newHelpText := "Create a new project from a template.  By default, the " +
+		"project is created from the apache/incubator-mynewt-blinky " +
+		"skeleton.  A template can be a directory, a git url (optionally " +
+		"followed by #<branch, tag, or commit>), or the name of a template " +
+		"in ~/.newt/templates.yml.  Occurrences of {{project.name}} and " +
+		"{{bsp}} in the template's files are replaced with the name of " +
+		"the project directory and the BSP."
+	newHelpEx := "  newt new myproj\n"
+	newHelpEx += "  newt new myproj --template ~/templates/myproj\n"
+	newHelpEx += "  newt new myproj --template " +
+		"https://git.example.com/template.git#v1.0 --bsp " +
+		"@apache-mynewt-core/hw/bsp/nrf52dk\n"
+	newHelpEx += "  newt new myproj -t acme"
 	newCmd := &cobra.Command{
 		Use:     "new <project-dir>",
 		Short:   "Create a new project",
@@ -197,6 +209,10 @@ func AddProjectCommands(cmd *cobra.Command) {
 		Example: newHelpEx,
 		Run:     newRunCmd,
 	}
+	newCmd.PersistentFlags().StringVarP(&newTemplate, "template", "t", "",
+		"Template to create the project from (name, url, or directory)")
+	newCmd.PersistentFlags().StringVarP(&newBsp, "bsp", "", "",
+		"BSP substituted for {{bsp}} in the template")
 
 	cmd.AddCommand(newCmd)
 
@@ -0,0 +1,125 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+var repoStatusJson bool = false
+
+func repoMirrorRunCmd(cmd *cobra.Command, args []string) {
+	if err := project.Initialize(); err != nil {
+		NewtUsage(cmd, err)
+	}
+	proj := project.GetProject()
+	interfaces.SetProject(proj)
+
+	if err := proj.Mirror(); err != nil {
+		NewtUsage(cmd, err)
+	}
+}
+
+func repoStatusRunCmd(cmd *cobra.Command, args []string) {
+	if err := project.Initialize(); err != nil {
+		NewtUsage(cmd, err)
+	}
+	proj := project.GetProject()
+	interfaces.SetProject(proj)
+
+	statuses, err := proj.RepoStatuses()
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	if repoStatusJson {
+		buffer, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			NewtUsage(cmd, util.NewNewtError(fmt.Sprintf("Cannot encode "+
+				"repository status: %s", err.Error())))
+		}
+		fmt.Printf("%s\n", buffer)
+		return
+	}
+
+	for _, rs := range statuses {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s", rs.String())
+	}
+}
+
+func AddRepoCommands(cmd *cobra.Command) {
+	repoHelpText := ""
+	repoHelpEx := ""
+	repoCmd := &cobra.Command{
+		Use:     "repo",
+		Short:   "Command for managing repositories",
+		Long:    repoHelpText,
+		Example: repoHelpEx,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Usage()
+		},
+	}
+
+	cmd.AddCommand(repoCmd)
+
+	mirrorHelpText := "Mirror every repository the project depends on into " +
+		"the repo cache (~/.newt/repo-cache), such that the project can be " +
+		"installed or upgraded with --offline."
+	mirrorHelpEx := "  newt repo mirror\n"
+	mirrorHelpEx += "  newt install --offline"
+
+	mirrorCmd := &cobra.Command{
+		Use:     "mirror",
+		Short:   "Populate the repo cache for offline use",
+		Long:    mirrorHelpText,
+		Example: mirrorHelpEx,
+		Run:     repoMirrorRunCmd,
+	}
+
+	repoCmd.AddCommand(mirrorCmd)
+
+	statusHelpText := "Show the state of each repository in the project: " +
+		"the version required by project.yml, the installed version, " +
+		"whether an upgrade is available, the checked out commit, and " +
+		"whether the working tree has local modifications.  Available " +
+		"versions are determined from the repository descriptions " +
+		"downloaded by the last install or upgrade."
+	statusHelpEx := "  newt repo status\n"
+	statusHelpEx += "  newt repo status --json"
+
+	statusCmd := &cobra.Command{
+		Use:     "status",
+		Short:   "Show the state of installed repositories",
+		Long:    statusHelpText,
+		Example: statusHelpEx,
+		Run:     repoStatusRunCmd,
+	}
+	statusCmd.PersistentFlags().BoolVarP(&repoStatusJson, "json", "", false,
+		"Output the status in JSON format")
+
+	repoCmd.AddCommand(statusCmd)
+}
@@ -23,7 +23,6 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
-	"mynewt.apache.org/newt/newt/builder"
 	"mynewt.apache.org/newt/newt/image"
 	"mynewt.apache.org/newt/newt/project"
 	"mynewt.apache.org/newt/util"
@@ -42,7 +41,7 @@ func runRunCmd(cmd *cobra.Command, args []string) {
 		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
 	}
 
-	b, err := builder.NewBuilder(t)
+	b, err := newOverriddenBuilder(t)
 	if err != nil {
 		NewtUsage(nil, err)
 	}
@@ -104,5 +103,6 @@ func AddRunCommands(cmd *cobra.Command) {
 		Example: runHelpEx,
 		Run:     runRunCmd,
 	}
+	addBuildOverrideFlags(runCmd)
 	cmd.AddCommand(runCmd)
 }
@@ -111,19 +111,22 @@ func targetShowCmd(cmd *cobra.Command, args []string) {
 	for _, name := range targetNames {
 		kvPairs := map[string]string{}
 
+		// Name of the target each value comes from, for inherited values.
+		sources := map[string]string{}
+
 		util.StatusMessage(util.VERBOSITY_DEFAULT, name+"\n")
 
 		target := target.GetTargets()[name]
 		for k, v := range target.Vars {
 			kvPairs[strings.TrimPrefix(k, "target.")] = v
+			sources[strings.TrimPrefix(k, "target.")] = target.Source(k)
 		}
 
 		// A few variables come from the base package rather than the target.
-		kvPairs["features"] = pkgVarSliceString(target.Package(),
-			"pkg.features")
-		kvPairs["cflags"] = pkgVarSliceString(target.Package(), "pkg.cflags")
-		kvPairs["lflags"] = pkgVarSliceString(target.Package(), "pkg.lflags")
-		kvPairs["aflags"] = pkgVarSliceString(target.Package(), "pkg.aflags")
+		for _, k := range []string{"features", "cflags", "lflags", "aflags"} {
+			kvPairs[k] = pkgVarSliceString(target.Package(), "pkg."+k)
+			sources[k] = target.Source("pkg." + k)
+		}
 
 		keys := []string{}
 		for k, _ := range kvPairs {
@@ -131,10 +134,17 @@ func targetShowCmd(cmd *cobra.Command, args []string) {
 		}
 		sort.Strings(keys)
 		for _, k := range keys {
-			val := kvPairs[k]
-			if len(val) > 0 {
+			val := strings.TrimSpace(kvPairs[k])
+			if len(val) == 0 {
+				continue
+			}
+
+			if sources[k] != target.FullName() {
+				util.StatusMessage(util.VERBOSITY_DEFAULT,
+					"    %s=%s (from %s)\n", k, val, sources[k])
+			} else {
 				util.StatusMessage(util.VERBOSITY_DEFAULT, "    %s=%s\n",
-					k, kvPairs[k])
+					k, val)
 			}
 		}
 	}
@@ -361,6 +371,7 @@ func targetVarsCmd(cmd *cobra.Command, args []string) {
 		"target.app",
 		"target.bsp",
 		"target.build_profile",
+		"target.extends",
 		"target.features",
 	}
 
@@ -411,10 +422,13 @@ func AddTargetCommands(cmd *cobra.Command) {
 	targetCmd.AddCommand(showCmd)
 
 	setHelpText := "Set a target variable (<var-name>) on target " +
-		"<target-name> to value <value>."
+		"<target-name> to value <value>.  Setting extends to the name of " +
+		"another target makes the target inherit that target's variables, " +
+		"features, and flags; the target's own settings take precedence."
 	setHelpEx := "  newt target set <target-name> <var-name>=<value>\n"
 	setHelpEx += "  newt target set my_target1 var_name=value\n"
 	setHelpEx += "  newt target set my_target1 arch=cortex_m4\n"
+	setHelpEx += "  newt target set my_target1 extends=my_base_target\n"
 	setHelpEx += "  newt target set my_target1 var_name   (display valid values for <var_name>)"
 
 	setCmd := &cobra.Command{
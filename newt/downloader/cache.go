@@ -0,0 +1,186 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package downloader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+// Subdirectory of the per-user newt directory containing the repo cache.
+const REPO_CACHE_DIR = "repo-cache"
+
+// When set, repositories and their descriptions are read from the repo
+// cache rather than the network.
+var Offline bool = false
+
+var mirrorNameRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Returns the path of the repo cache (~/.newt/repo-cache).
+func RepoCacheDir() (string, error) {
+	userDir, err := newtutil.NewtUserDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(userDir, REPO_CACHE_DIR), nil
+}
+
+// Returns the path of the bare mirror of the specified repository url.
+// Mirrors are named after their url, so they are shared across projects.
+func MirrorPath(url string) (string, error) {
+	cacheDir, err := RepoCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := url
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = name[i+3:]
+	}
+	name = strings.Trim(mirrorNameRe.ReplaceAllString(name, "_"), "_")
+	if !strings.HasSuffix(name, ".git") {
+		name += ".git"
+	}
+
+	return filepath.Join(cacheDir, name), nil
+}
+
+// Returns the path of an existing mirror of the specified url.
+func cachedMirror(url string) (string, error) {
+	mirror, err := MirrorPath(url)
+	if err != nil {
+		return "", err
+	}
+
+	if util.NodeNotExist(mirror) {
+		return "", util.NewNewtError(fmt.Sprintf("Repository %s is not in "+
+			"the repo cache (%s); run \"newt repo mirror\" while online",
+			url, mirror))
+	}
+
+	return mirror, nil
+}
+
+// Creates or updates the bare mirror of the specified repository url.
+func MirrorRepo(url string) error {
+	mirror, err := MirrorPath(url)
+	if err != nil {
+		return err
+	}
+
+	if util.NodeExist(mirror) {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "Updating mirror %s of "+
+			"%s\n", mirror, url)
+		_, err := gitCommand(mirror, "fetch", "--prune", "--tags")
+		return err
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "Creating mirror %s of %s\n",
+		mirror, url)
+
+	if err := os.MkdirAll(filepath.Dir(mirror), 0755); err != nil {
+		return util.NewNewtError(err.Error())
+	}
+
+	if _, err := gitCommand("", "clone", "--mirror", url,
+		mirror); err != nil {
+
+		os.RemoveAll(mirror)
+		return err
+	}
+
+	return nil
+}
+
+// Retrieves a file from the specified branch of a cached repository.
+func fetchCachedFile(url string, branch string, name string,
+	dest string) error {
+
+	mirror, err := cachedMirror(url)
+	if err != nil {
+		return err
+	}
+
+	contents, err := gitCommand(mirror, "show", branch+":"+name)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(dest, contents, 0644); err != nil {
+		return util.NewNewtError(err.Error())
+	}
+
+	return nil
+}
+
+// Clones a cached repository into a temporary directory, and checks out the
+// specified branch, tag, or commit.  The clone's origin refers to the real
+// url, so that it can be updated once back online.
+func downloadCachedRepo(url string, commit string) (string, error) {
+	mirror, err := cachedMirror(url)
+	if err != nil {
+		return "", err
+	}
+
+	tmpdir, err := ioutil.TempDir("", "newt-repo")
+	if err != nil {
+		return "", err
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "Copying cached repository "+
+		"%s (commit: %s) from %s\n", url, commit, mirror)
+
+	if _, err := gitCommand("", "clone", mirror, tmpdir); err != nil {
+		os.RemoveAll(tmpdir)
+		return "", err
+	}
+
+	if _, err := gitCommand(tmpdir, "remote", "set-url", "origin",
+		url); err != nil {
+
+		os.RemoveAll(tmpdir)
+		return "", err
+	}
+
+	if _, err := gitCommand(tmpdir, "checkout", commit); err != nil {
+		os.RemoveAll(tmpdir)
+		return "", err
+	}
+
+	return tmpdir, nil
+}
+
+// Returns the location to fetch the specified url from: the url itself, or
+// its mirror when offline.
+func fetchSource(url string) (string, error) {
+	if !Offline {
+		return url, nil
+	}
+
+	return cachedMirror(url)
+}
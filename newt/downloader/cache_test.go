@@ -0,0 +1,159 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Points the repo cache at the test repository's temporary directory, and
+// returns a function which restores the environment.
+func useTestCache(t *testing.T, tr *testRepo) func() {
+	oldHome := os.Getenv("HOME")
+	if err := os.Setenv("HOME", tr.baseDir); err != nil {
+		t.Fatal(err)
+	}
+
+	return func() {
+		os.Setenv("HOME", oldHome)
+		Offline = false
+	}
+}
+
+func TestMirrorPath(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+	defer useTestCache(t, tr)()
+
+	cacheDir, err := RepoCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for url, name := range map[string]string{
+		"https://github.com/apache/incubator-mynewt-core": "github.com_" +
+			"apache_incubator-mynewt-core.git",
+		"git@example.com:mynewt/core.git": "git_example.com_mynewt_core.git",
+	} {
+		mirror, err := MirrorPath(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := filepath.Join(cacheDir, name); mirror != expected {
+			t.Errorf("Mirror of %s: expected %s, got %s", url, expected,
+				mirror)
+		}
+	}
+}
+
+func TestOfflineUncached(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+	defer useTestCache(t, tr)()
+
+	Offline = true
+
+	dl := NewGitDownloader()
+	dl.Url = tr.url
+	dl.SetBranch("master")
+
+	err := dl.FetchFile("repository.yml",
+		filepath.Join(tr.baseDir, "repository.yml"))
+	if err == nil || !strings.Contains(err.Error(), "not in the repo cache") {
+		t.Errorf("Expected uncached repository error, got %v", err)
+	}
+
+	if _, err := dl.DownloadRepo("master"); err == nil {
+		t.Errorf("Offline download of an uncached repository succeeded")
+	}
+}
+
+func TestOfflineCached(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+	defer useTestCache(t, tr)()
+
+	if err := MirrorRepo(tr.url); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is read from the original repository while offline.
+	bareDir := strings.TrimPrefix(tr.url, "file://")
+	hiddenDir := bareDir + ".hidden"
+	if err := os.Rename(bareDir, hiddenDir); err != nil {
+		t.Fatal(err)
+	}
+	Offline = true
+
+	dl := NewGitDownloader()
+	dl.Url = tr.url
+	dl.SetBranch("1.0")
+
+	dest := filepath.Join(tr.baseDir, "repository.yml")
+	if err := dl.FetchFile("repository.yml", dest); err != nil {
+		t.Fatal(err)
+	}
+	if contents := readTestFile(t, dest); contents != "repo.name: 1.0\n" {
+		t.Errorf("Unexpected cached file contents: %q", contents)
+	}
+
+	dir, err := dl.DownloadRepo("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := tr.git(tr.workDir, "rev-parse", "master")
+	if head, _ := HeadCommit(dir); head != expected {
+		t.Errorf("Expected commit %s, got %s", expected, head)
+	}
+
+	// The copy's origin is the real url, so that it can be updated online.
+	if origin := tr.git(dir, "config", "remote.origin.url"); origin != tr.url {
+		t.Errorf("Expected origin %s, got %s", tr.url, origin)
+	}
+
+	// An offline update fetches from the mirror, which is refreshed while
+	// online.
+	if err := os.Rename(hiddenDir, bareDir); err != nil {
+		t.Fatal(err)
+	}
+	commit := tr.commit("master", "repository.yml", "repo.name: new\n")
+
+	Offline = false
+	if err := MirrorRepo(tr.url); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(bareDir, hiddenDir); err != nil {
+		t.Fatal(err)
+	}
+	Offline = true
+
+	if err := dl.UpdateRepo(dir, "master", false); err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := HeadCommit(dir); head != commit {
+		t.Errorf("Expected commit %s after offline update, got %s", commit,
+			head)
+	}
+}
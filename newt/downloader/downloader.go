@@ -20,6 +20,8 @@
 package downloader
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -38,6 +40,8 @@ type Downloader interface {
 	Branch() string
 	SetBranch(branch string)
 	DownloadRepo(branch string) (string, error)
+	UpdateRepo(path string, commit string, force bool) error
+	RepoUrl() string
 }
 
 type GenericDownloader struct {
@@ -50,6 +54,12 @@ type GithubDownloader struct {
 	Repo string
 }
 
+// Downloads from any git repository url (e.g., https://, ssh://, file://).
+type GitDownloader struct {
+	GenericDownloader
+	Url string
+}
+
 func (gd *GenericDownloader) Branch() string {
 	return gd.branch
 }
@@ -64,6 +74,10 @@ func (gd *GenericDownloader) TempDir() (string, error) {
 }
 
 func (gd *GithubDownloader) FetchFile(name string, dest string) error {
+	if Offline {
+		return fetchCachedFile(gd.RepoUrl(), gd.Branch(), name, dest)
+	}
+
 	fmtStr := "https://raw.githubusercontent.com/%s/%s/%s/%s"
 	url := fmt.Sprintf(fmtStr, gd.User, gd.Repo, gd.Branch(), name)
 
@@ -75,18 +89,41 @@ func (gd *GithubDownloader) FetchFile(name string, dest string) error {
 	}
 	defer rsp.Body.Close()
 
+	if rsp.StatusCode != http.StatusOK {
+		return util.NewNewtError(fmt.Sprintf("Error fetching %s: %s", url,
+			rsp.Status))
+	}
+
 	handle, err := os.Create(dest)
 	if err != nil {
 		return util.NewNewtError(err.Error())
 	}
 	defer handle.Close()
 
-	_, err = io.Copy(handle, rsp.Body)
+	if _, err := io.Copy(handle, rsp.Body); err != nil {
+		os.Remove(dest)
+		return util.NewNewtError(fmt.Sprintf("Error fetching %s: %s", url,
+			err.Error()))
+	}
 
 	return nil
 }
 
+func (gd *GithubDownloader) RepoUrl() string {
+	return fmt.Sprintf("https://github.com/%s/%s.git", gd.User, gd.Repo)
+}
+
+func (gd *GithubDownloader) UpdateRepo(path string, commit string,
+	force bool) error {
+
+	return updateRepo(path, gd.RepoUrl(), commit, force)
+}
+
 func (gd *GithubDownloader) DownloadRepo(commit string) (string, error) {
+	if Offline {
+		return downloadCachedRepo(gd.RepoUrl(), commit)
+	}
+
 	// Retrieve the current directory so that we can get back to where we
 	// started after the download completes.
 	pwd, err := os.Getwd()
@@ -103,10 +140,9 @@ func (gd *GithubDownloader) DownloadRepo(commit string) (string, error) {
 	// Currently only the master branch is supported.
 	branch := "master"
 
-	url := fmt.Sprintf("https://github.com/%s/%s.git", gd.User, gd.Repo)
-	util.StatusMessage(util.VERBOSITY_VERBOSE, fmt.Sprintf("Downloading "+
-		"repository %s (branch: %s; commit: %s) at %s\n", gd.Repo, branch,
-		commit, url))
+	url := gd.RepoUrl()
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "Downloading repository %s "+
+		"(branch: %s; commit: %s) at %s\n", gd.Repo, branch, commit, url)
 
 	gitPath, err := exec.LookPath("git")
 	if err != nil {
@@ -161,3 +197,250 @@ func (gd *GithubDownloader) DownloadRepo(commit string) (string, error) {
 func NewGithubDownloader() *GithubDownloader {
 	return &GithubDownloader{}
 }
+
+// Runs a git command in the specified directory and returns its standard
+// output.
+func gitCommand(dir string, args ...string) ([]byte, error) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return nil, util.NewNewtError(fmt.Sprintf("Can't find git binary: %s\n",
+			err.Error()))
+	}
+
+	log.Debugf("%s %s (dir: %s)", gitPath, strings.Join(args, " "), dir)
+
+	cmd := exec.Command(gitPath, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	o, err := cmd.Output()
+	if err != nil {
+		return nil, util.NewNewtError(fmt.Sprintf("git %s failed: %s",
+			strings.Join(args, " "), stderr.String()))
+	}
+
+	return o, nil
+}
+
+// Retrieves a single file by making a shallow clone of the requested branch
+// without checking out the working tree, and reading the file from the
+// resulting commit.
+func (gd *GitDownloader) FetchFile(name string, dest string) error {
+	if Offline {
+		return fetchCachedFile(gd.Url, gd.Branch(), name, dest)
+	}
+
+	log.Debugf("Fetching file %s (url: %s; branch: %s) to %s", name, gd.Url,
+		gd.Branch(), dest)
+
+	tmpdir, err := gd.TempDir()
+	if err != nil {
+		return util.NewNewtError(err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if _, err := gitCommand("", "clone", "--depth", "1", "--no-checkout",
+		"-b", gd.Branch(), gd.Url, tmpdir); err != nil {
+
+		return err
+	}
+
+	contents, err := gitCommand(tmpdir, "show", "HEAD:"+name)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(dest, contents, 0644); err != nil {
+		return util.NewNewtError(err.Error())
+	}
+
+	return nil
+}
+
+func (gd *GitDownloader) RepoUrl() string {
+	return gd.Url
+}
+
+func (gd *GitDownloader) UpdateRepo(path string, commit string,
+	force bool) error {
+
+	return updateRepo(path, gd.Url, commit, force)
+}
+
+func (gd *GitDownloader) DownloadRepo(commit string) (string, error) {
+	if Offline {
+		return downloadCachedRepo(gd.Url, commit)
+	}
+
+	// Get a temporary directory, and copy the repository into that directory.
+	tmpdir, err := ioutil.TempDir("", "newt-repo")
+	if err != nil {
+		return "", err
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "Downloading repository "+
+		"(commit: %s) at %s\n", commit, gd.Url)
+
+	if _, err := gitCommand("", "clone", gd.Url, tmpdir); err != nil {
+		os.RemoveAll(tmpdir)
+		return "", err
+	}
+
+	// Checkout the specified commit.
+	if _, err := gitCommand(tmpdir, "checkout", commit); err != nil {
+		os.RemoveAll(tmpdir)
+		return "", err
+	}
+
+	return tmpdir, nil
+}
+
+// Returns the hash of the commit checked out in the specified git working
+// tree.
+func HeadCommit(dir string) (string, error) {
+	o, err := gitCommand(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(o)), nil
+}
+
+// Returns the SHA-256 hash of the specified file, as a hex string.
+func FileHash(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", util.NewNewtError(err.Error())
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(contents)), nil
+}
+
+// Indicates whether the specified git working tree contains uncommitted
+// changes to tracked files.
+func HasLocalChanges(dir string) (bool, error) {
+	o, err := gitCommand(dir, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return false, err
+	}
+
+	return strings.TrimSpace(string(o)) != "", nil
+}
+
+// Indicates whether the specified local branch has commits which are not on
+// the fetched remote branch of the same name.
+func HasUnpushedCommits(dir string, branch string) (bool, error) {
+	localBranch := "refs/heads/" + branch
+	if _, err := gitCommand(dir, "rev-parse", "--verify", "--quiet",
+		localBranch); err != nil {
+
+		// No local branch; nothing to lose.
+		return false, nil
+	}
+
+	o, err := gitCommand(dir, "rev-list",
+		"refs/remotes/origin/"+branch+".."+localBranch)
+	if err != nil {
+		return false, err
+	}
+
+	return strings.TrimSpace(string(o)) != "", nil
+}
+
+// Brings an existing git working tree up to date with the specified url, and
+// checks out the specified branch, tag, or commit.  A branch is reset to the
+// state of the remote branch; if the local branch has commits which aren't on
+// the remote, the update is refused.  If force is set, local modifications
+// and commits are discarded.  When offline, the url's mirror in the repo
+// cache is fetched from instead.
+func updateRepo(path string, url string, commit string, force bool) error {
+	src, err := fetchSource(url)
+	if err != nil {
+		return err
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "Updating repository at %s "+
+		"(commit: %s) from %s\n", path, commit, src)
+
+	if _, err := gitCommand(path, "remote", "set-url", "origin",
+		url); err != nil {
+
+		return err
+	}
+
+	if _, err := gitCommand(path, "fetch", "--tags", src,
+		"+refs/heads/*:refs/remotes/origin/*"); err != nil {
+
+		return err
+	}
+
+	args := []string{"checkout"}
+	if force {
+		args = append(args, "-f")
+	}
+
+	remoteBranch := "refs/remotes/origin/" + commit
+	if _, err := gitCommand(path, "rev-parse", "--verify", "--quiet",
+		remoteBranch); err == nil {
+
+		if !force {
+			unpushed, err := HasUnpushedCommits(path, commit)
+			if err != nil {
+				return err
+			}
+			if unpushed {
+				return util.NewNewtError(fmt.Sprintf("Branch %s of "+
+					"repository %s has commits which are not on the "+
+					"remote; push them, or provide -f to discard them.",
+					commit, path))
+			}
+		}
+
+		args = append(args, "-B", commit, remoteBranch)
+	} else {
+		args = append(args, commit)
+	}
+
+	if _, err := gitCommand(path, args...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func NewGitDownloader() *GitDownloader {
+	return &GitDownloader{}
+}
+
+// Creates a downloader from a repository's configuration, as specified in
+// project.yml or repository.yml.
+func LoadDownloader(repoName string,
+	repoVars map[string]string) (Downloader, error) {
+
+	switch repoVars["type"] {
+	case "":
+		return nil, util.NewNewtError("Missing type for repository " +
+			repoName)
+
+	case "github":
+		dl := NewGithubDownloader()
+		dl.User = repoVars["user"]
+		dl.Repo = repoVars["repo"]
+		return dl, nil
+
+	case "git":
+		if repoVars["url"] == "" {
+			return nil, util.NewNewtError("Missing url for repository " +
+				repoName)
+		}
+		dl := NewGitDownloader()
+		dl.Url = repoVars["url"]
+		return dl, nil
+
+	default:
+		return nil, util.NewNewtError(fmt.Sprintf("Invalid type for "+
+			"repository %s: %s; must be github or git", repoName,
+			repoVars["type"]))
+	}
+}
@@ -0,0 +1,170 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package downloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A bare repository in a temporary directory, along with the working tree
+// that commits are made in and pushed from.
+type testRepo struct {
+	t       *testing.T
+	baseDir string
+	workDir string
+	url     string
+}
+
+func (tr *testRepo) git(dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=newt", "-c",
+		"user.email=newt@example.com"}, args...)
+	o, err := gitCommand(dir, args...)
+	if err != nil {
+		tr.t.Fatalf("git %s: %s", strings.Join(args, " "), err.Error())
+	}
+	return strings.TrimSpace(string(o))
+}
+
+// Commits a file to the specified branch of the work tree and pushes it to
+// the bare repository.  Returns the new commit.
+func (tr *testRepo) commit(branch string, name string,
+	contents string) string {
+
+	tr.git(tr.workDir, "checkout", "-q", branch)
+	if err := ioutil.WriteFile(filepath.Join(tr.workDir, name),
+		[]byte(contents), 0644); err != nil {
+
+		tr.t.Fatal(err)
+	}
+	tr.git(tr.workDir, "add", name)
+	tr.git(tr.workDir, "commit", "-q", "-m", "Update "+name)
+	tr.git(tr.workDir, "push", "-q", "origin", branch)
+
+	return tr.git(tr.workDir, "rev-parse", "HEAD")
+}
+
+func (tr *testRepo) cleanup() {
+	os.RemoveAll(tr.baseDir)
+}
+
+// Creates a bare repository with a master branch and a 1.0 branch, each
+// containing a different repository.yml.
+func newTestRepo(t *testing.T) *testRepo {
+	baseDir, err := ioutil.TempDir("", "newt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := &testRepo{
+		t:       t,
+		baseDir: baseDir,
+		workDir: filepath.Join(baseDir, "work"),
+		url:     "file://" + filepath.Join(baseDir, "repo.git"),
+	}
+
+	tr.git(baseDir, "init", "-q", "--bare", "repo.git")
+	tr.git(filepath.Join(baseDir, "repo.git"), "symbolic-ref", "HEAD",
+		"refs/heads/master")
+
+	tr.git(baseDir, "init", "-q", "work")
+	tr.git(tr.workDir, "symbolic-ref", "HEAD", "refs/heads/master")
+	tr.git(tr.workDir, "remote", "add", "origin", tr.url)
+
+	if err := ioutil.WriteFile(filepath.Join(tr.workDir, "repository.yml"),
+		[]byte("repo.name: master\n"), 0644); err != nil {
+
+		t.Fatal(err)
+	}
+	tr.git(tr.workDir, "add", "repository.yml")
+	tr.git(tr.workDir, "commit", "-q", "-m", "Initial commit")
+	tr.git(tr.workDir, "push", "-q", "origin", "master")
+
+	tr.git(tr.workDir, "branch", "1.0")
+	tr.commit("1.0", "repository.yml", "repo.name: 1.0\n")
+
+	return tr
+}
+
+func readTestFile(t *testing.T, path string) string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+func TestGitDownloaderFetchFile(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	dl := NewGitDownloader()
+	dl.Url = tr.url
+	dest := filepath.Join(tr.baseDir, "repository.yml")
+
+	for _, branch := range []string{"master", "1.0"} {
+		dl.SetBranch(branch)
+		if err := dl.FetchFile("repository.yml", dest); err != nil {
+			t.Fatalf("Fetching from branch %s: %s", branch, err.Error())
+		}
+
+		expected := "repo.name: " + branch + "\n"
+		if contents := readTestFile(t, dest); contents != expected {
+			t.Errorf("Branch %s: expected %q, got %q", branch, expected,
+				contents)
+		}
+	}
+
+	if err := dl.FetchFile("missing.yml", dest); err == nil {
+		t.Errorf("Fetching a missing file succeeded")
+	}
+}
+
+func TestGitDownloaderDownloadRepo(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	dl := NewGitDownloader()
+	dl.Url = tr.url
+
+	dir, err := dl.DownloadRepo("1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if contents := readTestFile(t, filepath.Join(dir,
+		"repository.yml")); contents != "repo.name: 1.0\n" {
+
+		t.Errorf("Unexpected contents of branch 1.0: %q", contents)
+	}
+
+	expected := tr.git(tr.workDir, "rev-parse", "1.0")
+	if commit, err := HeadCommit(dir); err != nil || commit != expected {
+		t.Errorf("Expected commit %s, got %s (%v)", expected, commit, err)
+	}
+
+	if _, err := dl.DownloadRepo("no-such-branch"); err == nil {
+		t.Errorf("Downloading a missing branch succeeded")
+	}
+}
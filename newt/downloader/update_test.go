@@ -0,0 +1,96 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package downloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateRepo(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	dl := NewGitDownloader()
+	dl.Url = tr.url
+
+	dir, err := dl.DownloadRepo("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A branch is brought up to date with the remote.
+	commit := tr.commit("master", "repository.yml", "repo.name: new\n")
+	if err := dl.UpdateRepo(dir, "master", false); err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := HeadCommit(dir); head != commit {
+		t.Errorf("Expected commit %s after update, got %s", commit, head)
+	}
+
+	// A commit is checked out as is.
+	first := tr.git(tr.workDir, "rev-list", "--max-parents=0", "HEAD")
+	if err := dl.UpdateRepo(dir, first, false); err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := HeadCommit(dir); head != first {
+		t.Errorf("Expected commit %s after update, got %s", first, head)
+	}
+
+	// Modifications to tracked files are detected.
+	if changed, err := HasLocalChanges(dir); err != nil || changed {
+		t.Errorf("Unexpected local changes (%v)", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "repository.yml"),
+		[]byte("modified\n"), 0644); err != nil {
+
+		t.Fatal(err)
+	}
+	if changed, err := HasLocalChanges(dir); err != nil || !changed {
+		t.Errorf("Local changes not detected (%v)", err)
+	}
+	if err := dl.UpdateRepo(dir, "master", true); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := HasLocalChanges(dir); changed {
+		t.Errorf("Forced update kept local changes")
+	}
+
+	// Commits which aren't on the remote aren't discarded unless forced.
+	tr.git(dir, "commit", "-q", "--allow-empty", "-m", "Local commit")
+	local := tr.git(dir, "rev-parse", "HEAD")
+	if err := dl.UpdateRepo(dir, "master", false); err == nil {
+		t.Errorf("Update discarded unpushed commits")
+	}
+	if head, _ := HeadCommit(dir); head != local {
+		t.Errorf("Expected commit %s after refused update, got %s", local,
+			head)
+	}
+	if err := dl.UpdateRepo(dir, "master", true); err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := HeadCommit(dir); head != commit {
+		t.Errorf("Expected commit %s after forced update, got %s", commit,
+			head)
+	}
+}
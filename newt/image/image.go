@@ -125,6 +125,9 @@ type ImageManifest struct {
 	Image   string              `json:"image"`
 	Pkgs    []*ImageManifestPkg `json:"pkgs"`
 	TgtVars []string            `json:"target"`
+
+	// Settings specified for the build only, if any.
+	Overrides *builder.BuildOverrides `json:"overrides,omitempty"`
 }
 
 type ImageManifestPkg struct {
@@ -437,10 +440,11 @@ func (image *Image) CreateManifest(t *target.Target) error {
 	timeStr := time.Now().Format(time.RFC3339)
 
 	manifest := &ImageManifest{
-		Version: versionStr,
-		Hash:    hashStr,
-		Image:   filepath.Base(image.targetImg),
-		Date:    timeStr,
+		Version:   versionStr,
+		Hash:      hashStr,
+		Image:     filepath.Base(image.targetImg),
+		Date:      timeStr,
+		Overrides: image.builder.Overrides(),
 	}
 
 	for _, builtPkg := range image.builder.Packages {
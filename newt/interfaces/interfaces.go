@@ -24,6 +24,9 @@ type PackageInterface interface {
 	FullName() string
 	Repo() RepoInterface
 	Type() PackageType
+
+	// Nil if the package does not declare a version.
+	Version() VersionInterface
 }
 
 type PackageType int
@@ -46,6 +49,7 @@ type VersionInterface interface {
 	Minor() int64
 	Revision() int64
 	Stability() string
+	Prerelease() string
 	String() string
 }
 
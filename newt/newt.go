@@ -115,6 +115,9 @@ func newtCmd() *cobra.Command {
 func main() {
 	cmd := newtCmd()
 	cli.AddProjectCommands(cmd)
+	cli.AddRepoCommands(cmd)
+	cli.AddPackageCommands(cmd)
+	cli.AddLintCommands(cmd)
 	cli.AddTargetCommands(cmd)
 	cli.AddBuildCommands(cmd)
 	cli.AddImageCommands(cmd)
@@ -0,0 +1,273 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package newtutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// A condition on the set of enabled features, used to qualify a setting
+// (e.g., pkg.cflags.'BLE && !SELFTEST').  The grammar is:
+//
+//	expr    := and ("||" and)*
+//	and     := unary ("&&" unary)*
+//	unary   := "!" unary | primary
+//	primary := <feature> | "(" expr ")"
+//
+// Feature names consist of letters, digits, and underscores, and are
+// compared case-insensitively.  An expression may be enclosed in single or
+// double quotes, which are ignored.
+type FeatureExpr struct {
+	op       string
+	feature  string
+	operands []*FeatureExpr
+}
+
+type featExprParser struct {
+	text   string
+	tokens []string
+	pos    int
+}
+
+func isFeatureChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z')
+}
+
+func (p *featExprParser) errorf(format string, args ...interface{}) error {
+	return util.FmtNewtError("Invalid feature expression \"%s\": %s", p.text,
+		fmt.Sprintf(format, args...))
+}
+
+func (p *featExprParser) tokenize() error {
+	s := p.text
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+
+		case c == '!' || c == '(' || c == ')':
+			p.tokens = append(p.tokens, s[i:i+1])
+			i++
+
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||"):
+			p.tokens = append(p.tokens, s[i:i+2])
+			i += 2
+
+		case isFeatureChar(c):
+			start := i
+			for i < len(s) && isFeatureChar(s[i]) {
+				i++
+			}
+			p.tokens = append(p.tokens, s[start:i])
+
+		default:
+			return p.errorf("unexpected character '%c'", c)
+		}
+	}
+
+	return nil
+}
+
+func (p *featExprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *featExprParser) parseBinary(op string,
+	operand func() (*FeatureExpr, error)) (*FeatureExpr, error) {
+
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := []*FeatureExpr{first}
+	for p.peek() == op {
+		p.pos++
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, next)
+	}
+
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return &FeatureExpr{op: op, operands: operands}, nil
+}
+
+func (p *featExprParser) parseOr() (*FeatureExpr, error) {
+	return p.parseBinary("||", p.parseAnd)
+}
+
+func (p *featExprParser) parseAnd() (*FeatureExpr, error) {
+	return p.parseBinary("&&", p.parseUnary)
+}
+
+func (p *featExprParser) parseUnary() (*FeatureExpr, error) {
+	tok := p.peek()
+	switch {
+	case tok == "":
+		return nil, p.errorf("unexpected end of expression")
+
+	case tok == "!":
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &FeatureExpr{op: "!", operands: []*FeatureExpr{operand}}, nil
+
+	case tok == "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, p.errorf("missing ')'")
+		}
+		p.pos++
+		return expr, nil
+
+	case isFeatureChar(tok[0]):
+		p.pos++
+		return &FeatureExpr{feature: tok}, nil
+
+	default:
+		return nil, p.errorf("unexpected '%s'", tok)
+	}
+}
+
+// Parses a feature expression.
+func ParseFeatureExpr(text string) (*FeatureExpr, error) {
+	text = strings.TrimSpace(text)
+	if len(text) >= 2 && (text[0] == '\'' || text[0] == '"') &&
+		text[len(text)-1] == text[0] {
+
+		text = text[1 : len(text)-1]
+	}
+
+	p := &featExprParser{text: text}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	if len(p.tokens) == 0 {
+		return nil, p.errorf("empty expression")
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected '%s'", p.tokens[p.pos])
+	}
+
+	return expr, nil
+}
+
+// Parses the condition of a feature-qualified setting.  This is a feature
+// expression or, failing that, the exact name of a single feature or build
+// profile; such names may contain characters which an expression can't
+// (e.g., debug-size).
+func ParseFeatureCondition(text string) (*FeatureExpr, error) {
+	expr, err := ParseFeatureExpr(text)
+	if err == nil {
+		return expr, nil
+	}
+
+	name := strings.TrimSpace(text)
+	if name == "" || strings.ContainsAny(name, " \t!&|()'\"") {
+		return nil, err
+	}
+	return &FeatureExpr{feature: name}, nil
+}
+
+// Evaluates the expression.  The keys of the specified set of enabled
+// features must be in upper case; see UpperFeatures().
+func (e *FeatureExpr) Eval(upperFeatures map[string]bool) bool {
+	switch e.op {
+	case "!":
+		return !e.operands[0].Eval(upperFeatures)
+
+	case "&&":
+		for _, operand := range e.operands {
+			if !operand.Eval(upperFeatures) {
+				return false
+			}
+		}
+		return true
+
+	case "||":
+		for _, operand := range e.operands {
+			if operand.Eval(upperFeatures) {
+				return true
+			}
+		}
+		return false
+
+	default:
+		return upperFeatures[strings.ToUpper(e.feature)]
+	}
+}
+
+func (e *FeatureExpr) collectFeatures(names map[string]bool) {
+	if e.feature != "" {
+		names[e.feature] = true
+	}
+	for _, operand := range e.operands {
+		operand.collectFeatures(names)
+	}
+}
+
+// Returns the names of the features the expression refers to, sorted.
+func (e *FeatureExpr) Features() []string {
+	nameMap := map[string]bool{}
+	e.collectFeatures(nameMap)
+
+	names := make([]string, 0, len(nameMap))
+	for name, _ := range nameMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns a copy of a set of features with the names in upper case, for
+// evaluating expressions.
+func UpperFeatures(features map[string]bool) map[string]bool {
+	upper := make(map[string]bool, len(features))
+	for feature, enabled := range features {
+		if enabled {
+			upper[strings.ToUpper(feature)] = true
+		}
+	}
+	return upper
+}
@@ -20,7 +20,11 @@
 package newtutil
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -32,26 +36,102 @@ import (
 var NewtVersionStr string = "Apache Newt (incubating) version: 0.9.0"
 var NewtBlinkyTag string = "mynewt_0_9_0_tag"
 
-func GetStringFeatures(v *viper.Viper, features map[string]bool,
-	key string) string {
-	val := v.GetString(key)
+// Name of the directory in the user's home directory where newt keeps
+// per-user data.
+const NEWT_USER_DIR = ".newt"
+
+// Returns the path of the per-user newt directory (~/.newt).
+func NewtUserDir() (string, error) {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", util.NewNewtError("Cannot determine home directory; " +
+			"HOME is not set")
+	}
+
+	return filepath.Join(home, NEWT_USER_DIR), nil
+}
 
-	// Process the features in alphabetical order to ensure consistent
-	// results across repeated runs.
-	var featureKeys []string
-	for feature, _ := range features {
-		featureKeys = append(featureKeys, feature)
+// Suffix of a feature-qualified key whose value replaces the base value
+// rather than being appended to it (e.g., pkg.cflags.TEST.OVERWRITE).
+const FEATURE_OVERWRITE_SUFFIX = ".overwrite"
+
+// Finds the feature-qualified variants of the specified key whose conditions
+// hold.  A variant is named <key>.<cond> or <key>.<cond>.OVERWRITE, where
+// <cond> is a feature expression or a feature name (see
+// ParseFeatureCondition).  Keys nested more deeply (e.g.,
+// compiler.flags.base.LINUX) belong to other settings and are skipped.
+// Variants are considered in alphabetical order so that results are
+// consistent across runs.  If any OVERWRITE variant applies, the first one is
+// returned alone; otherwise all applicable append variants are returned.
+func featureKeys(v *viper.Viper, features map[string]bool,
+	key string) (appendKeys []string, overwriteKey string) {
+
+	prefix := strings.ToLower(key) + "."
+	keys := []string{}
+	for _, k := range v.AllKeys() {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
 	}
-	sort.Strings(featureKeys)
+	sort.Strings(keys)
+
+	upperFeatures := UpperFeatures(features)
+	for _, k := range keys {
+		cond := strings.TrimPrefix(k, prefix)
+		overwrite := strings.HasSuffix(cond, FEATURE_OVERWRITE_SUFFIX)
+		if overwrite {
+			cond = strings.TrimSuffix(cond, FEATURE_OVERWRITE_SUFFIX)
+		}
+		if strings.Contains(cond, ".") {
+			continue
+		}
 
-	for _, feature := range featureKeys {
-		overwriteVal := v.GetString(key + "." + feature + ".OVERWRITE")
-		if overwriteVal != "" {
-			val = strings.Trim(overwriteVal, "\n")
-			break
+		// A key which is the prefix of a longer one, other than its own
+		// OVERWRITE variant, is a parent setting (e.g., compiler.flags.base).
+		parent := false
+		for _, other := range keys {
+			if strings.HasPrefix(other, k+".") &&
+				other != k+FEATURE_OVERWRITE_SUFFIX {
+
+				parent = true
+				break
+			}
+		}
+		if parent {
+			continue
+		}
+
+		expr, err := ParseFeatureCondition(cond)
+		if err != nil {
+			util.ErrorMessage(util.VERBOSITY_QUIET,
+				"Warning: ignoring setting %s: %s\n", k,
+				err.(*util.NewtError).Text)
+			continue
+		}
+		if !expr.Eval(upperFeatures) {
+			continue
+		}
+
+		if overwrite {
+			return nil, k
 		}
+		appendKeys = append(appendKeys, k)
+	}
 
-		appendVal := v.GetString(key + "." + feature)
+	return appendKeys, ""
+}
+
+func GetStringFeatures(v *viper.Viper, features map[string]bool,
+	key string) string {
+
+	appendKeys, overwriteKey := featureKeys(v, features, key)
+	if overwriteKey != "" {
+		return strings.TrimSpace(v.GetString(overwriteKey))
+	}
+
+	val := v.GetString(key)
+	for _, k := range appendKeys {
+		appendVal := v.GetString(k)
 		if appendVal != "" {
 			val += " " + strings.Trim(appendVal, "\n")
 		}
@@ -79,6 +159,11 @@ func GetBoolFeatures(v *viper.Viper, features map[string]bool,
 func GetStringSliceFeatures(v *viper.Viper, features map[string]bool,
 	key string) []string {
 
+	appendKeys, overwriteKey := featureKeys(v, features, key)
+	if overwriteKey != "" {
+		return v.GetStringSlice(overwriteKey)
+	}
+
 	val := v.GetStringSlice(key)
 
 	// string empty items
@@ -90,19 +175,62 @@ func GetStringSliceFeatures(v *viper.Viper, features map[string]bool,
 		result = append(result, item)
 	}
 
-	for item, _ := range features {
-		overwriteVal := v.GetStringSlice(key + "." + item + ".OVERWRITE")
-		if overwriteVal != nil {
-			result = overwriteVal
-			break
-		}
+	for _, k := range appendKeys {
+		result = append(result, v.GetStringSlice(k)...)
+	}
 
-		result = append(result, v.GetStringSlice(key+"."+item)...)
+	return result
+}
+
+// Collects the settings nested under the specified key.  Settings can be
+// specified either as a map or as flattened "key.subkey" entries.  Subkeys are
+// lowercase, as viper is case-insensitive.
+func GetStringMapFlat(v *viper.Viper, key string) map[string]string {
+	result := map[string]string{}
+
+	for k, val := range v.GetStringMapString(key) {
+		result[strings.ToLower(k)] = val
+	}
+
+	prefix := strings.ToLower(key) + "."
+	for _, k := range v.AllKeys() {
+		if strings.HasPrefix(k, prefix) {
+			result[strings.TrimPrefix(k, prefix)] = v.GetString(k)
+		}
 	}
 
 	return result
 }
 
+// Writes a generated file, creating its directory if necessary.  The file is
+// only written if its contents change, so that regenerating an unchanged file
+// doesn't cause a rebuild.
+func WriteFileIfChanged(path string, contents []byte) error {
+	if old, err := ioutil.ReadFile(path); err == nil &&
+		bytes.Equal(old, contents) {
+
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return util.NewNewtError(err.Error())
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		return util.NewNewtError(err.Error())
+	}
+
+	return nil
+}
+
+// Replaces each occurrence of {{<name>}} in the specified text with the
+// value of the named variable.  Unknown variables are left as is.
+func ExpandTemplateVars(text string, vars map[string]string) string {
+	for name, value := range vars {
+		text = strings.Replace(text, "{{"+name+"}}", value, -1)
+	}
+	return text
+}
+
 // Parses a string of the following form:
 //     [@repo]<path/to/package>
 //
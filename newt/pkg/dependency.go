@@ -20,21 +20,47 @@
 package pkg
 
 import (
+	"regexp"
+	"strings"
+
 	"mynewt.apache.org/newt/newt/interfaces"
 	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
 )
 
+// Splits a dependency string into the package name and the version
+// requirements which follow it (e.g., "@apache-mynewt-core/sys/log >= 0.9").
+var depStringRe = regexp.MustCompile(`^\s*([^\s<>=^~,]+)\s*(.*)$`)
+
 type Dependency struct {
 	Name string
 	Repo string
+
+	// Requirements on the version of the package, as declared by its
+	// pkg.version setting; nil if any version will do.
+	VersionReqs []interfaces.VersionReqInterface
+}
+
+func (dep *Dependency) VersionReqsString() string {
+	strs := make([]string, len(dep.VersionReqs))
+	for i, req := range dep.VersionReqs {
+		strs[i] = req.String()
+	}
+	return strings.Join(strs, " ")
 }
 
 func (dep *Dependency) String() string {
-	return newtutil.BuildPackageString(dep.Repo, dep.Name)
+	str := newtutil.BuildPackageString(dep.Repo, dep.Name)
+	if dep.VersionReqs != nil {
+		str += " " + dep.VersionReqsString()
+	}
+	return str
 }
 
-func (dep *Dependency) SatisfiesDependency(pkg interfaces.PackageInterface) bool {
+// Indicates whether the package is the one the dependency names, regardless
+// of its version.
+func (dep *Dependency) SatisfiesName(pkg interfaces.PackageInterface) bool {
 	if dep.Name != pkg.Name() {
 		return false
 	}
@@ -46,6 +72,26 @@ func (dep *Dependency) SatisfiesDependency(pkg interfaces.PackageInterface) bool
 	return true
 }
 
+// Indicates whether the package's version meets the dependency's version
+// requirements.  A package which does not declare a version only satisfies
+// dependencies without requirements.
+func (dep *Dependency) SatisfiesVersion(pkg interfaces.PackageInterface) bool {
+	if dep.VersionReqs == nil {
+		return true
+	}
+
+	vers := pkg.Version()
+	if vers == nil {
+		return false
+	}
+
+	return vers.SatisfiesVersion(dep.VersionReqs)
+}
+
+func (dep *Dependency) SatisfiesDependency(pkg interfaces.PackageInterface) bool {
+	return dep.SatisfiesName(pkg) && dep.SatisfiesVersion(pkg)
+}
+
 func (dep *Dependency) setRepoAndName(parentRepo interfaces.RepoInterface, str string) error {
 	// First part is always repo/dependency name combination.
 	// If repo is present, string will always begin with a @ sign
@@ -71,10 +117,24 @@ func (dep *Dependency) setRepoAndName(parentRepo interfaces.RepoInterface, str s
 }
 
 func (dep *Dependency) Init(parentRepo interfaces.RepoInterface, depStr string) error {
-	if err := dep.setRepoAndName(parentRepo, depStr); err != nil {
+	m := depStringRe.FindStringSubmatch(depStr)
+	if m == nil {
+		return util.NewNewtError("Invalid dependency: " + depStr)
+	}
+
+	if err := dep.setRepoAndName(parentRepo, m[1]); err != nil {
 		return err
 	}
 
+	if m[2] != "" {
+		reqs, err := repo.LoadVersionMatches(m[2])
+		if err != nil {
+			return util.FmtNewtError("Invalid dependency: %s; %s", depStr,
+				err.(*util.NewtError).Text)
+		}
+		dep.VersionReqs = reqs
+	}
+
 	return nil
 }
 
@@ -26,6 +26,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -33,6 +34,7 @@ import (
 	"mynewt.apache.org/newt/newt/interfaces"
 	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/newt/schema"
 	"mynewt.apache.org/newt/util"
 	"mynewt.apache.org/newt/viper"
 	"mynewt.apache.org/newt/yaml"
@@ -56,6 +58,9 @@ type LocalPackage struct {
 	basePath    string
 	packageType interfaces.PackageType
 
+	// Version declared by pkg.version; nil if none.
+	version *repo.Version
+
 	// General information about the package
 	desc *PackageDesc
 	// Dependencies for this package
@@ -70,6 +75,10 @@ type LocalPackage struct {
 
 	// Names of all source yml files; used to determine if rebuild required.
 	cfgFilenames []string
+
+	// Values which apply unless pkg.yml specifies its own (e.g., settings a
+	// target inherits from the target it extends).  These are not saved.
+	inherited map[string]interface{}
 }
 
 func NewLocalPackage(r *repo.Repo, pkgDir string) *LocalPackage {
@@ -103,6 +112,14 @@ func (pkg *LocalPackage) Type() interfaces.PackageType {
 	return pkg.packageType
 }
 
+func (pkg *LocalPackage) Version() interfaces.VersionInterface {
+	// Avoid returning a non-nil interface holding a nil pointer.
+	if pkg.version == nil {
+		return nil
+	}
+	return pkg.version
+}
+
 func (pkg *LocalPackage) Repo() interfaces.RepoInterface {
 	return pkg.repo
 }
@@ -174,6 +191,11 @@ func (pkg *LocalPackage) CfgFilenames() []string {
 }
 
 func (pkg *LocalPackage) AddCfgFilename(cfgFilename string) {
+	for _, name := range pkg.cfgFilenames {
+		if name == cfgFilename {
+			return
+		}
+	}
 	pkg.cfgFilenames = append(pkg.cfgFilenames, cfgFilename)
 }
 
@@ -228,9 +250,33 @@ func (pkg *LocalPackage) Init(repo *repo.Repo, pkgDir string) {
 	pkg.basePath = filepath.Clean(pkgDir) + "/"
 }
 
+// Sets a value which applies unless pkg.yml specifies its own.
+func (pkg *LocalPackage) SetInheritedValue(key string, val interface{}) {
+	if pkg.inherited == nil {
+		pkg.inherited = map[string]interface{}{}
+	}
+	pkg.inherited[strings.ToLower(key)] = val
+	pkg.Viper.SetDefault(key, val)
+}
+
+// Indicates whether the value of a setting is one set with
+// SetInheritedValue, rather than one specified in pkg.yml or changed since.
+func (pkg *LocalPackage) IsInheritedValue(key string) bool {
+	val, ok := pkg.inherited[strings.ToLower(key)]
+	if !ok || pkg.Viper.InConfig(key) {
+		return false
+	}
+
+	return reflect.DeepEqual(pkg.Viper.Get(key), val)
+}
+
 func (pkg *LocalPackage) sequenceString(key string) string {
 	var buffer bytes.Buffer
 
+	if pkg.IsInheritedValue(key) {
+		return ""
+	}
+
 	if pkg.Viper != nil {
 		for _, f := range pkg.Viper.GetStringSlice(key) {
 			buffer.WriteString("    - " + yaml.EscapeString(f) + "\n")
@@ -273,6 +319,10 @@ func (pkg *LocalPackage) Save() error {
 		yaml.EscapeString(pkg.Desc().Author) + "\n")
 	file.WriteString("pkg.homepage: " +
 		yaml.EscapeString(pkg.Desc().Homepage) + "\n")
+	if pkg.version != nil {
+		file.WriteString("pkg.version: " +
+			yaml.EscapeString(pkg.version.String()) + "\n")
+	}
 
 	file.WriteString("\n")
 
@@ -297,6 +347,17 @@ func (pkg *LocalPackage) Load() error {
 	}
 	pkg.Viper = v
 
+	// Reject values that would be misinterpreted, e.g., an unknown package
+	// type.
+	f, err := schema.PackageSchema.ValidateFile(pkg.basePath +
+		PACKAGE_FILE_NAME)
+	if err != nil {
+		return err
+	}
+	if err := f.FatalError(); err != nil {
+		return err
+	}
+
 	// Set package name from the package
 	pkg.name = v.GetString("pkg.name")
 
@@ -309,6 +370,12 @@ func (pkg *LocalPackage) Load() error {
 		}
 	}
 
+	pkg.version, err = repo.LoadVersion(v.GetString("pkg.version"))
+	if err != nil {
+		return util.FmtNewtError("%s: invalid pkg.version: %s",
+			pkg.basePath+PACKAGE_FILE_NAME, err.(*util.NewtError).Text)
+	}
+
 	// Read the package description from the file
 	pkg.desc, err = pkg.readDesc(v)
 	if err != nil {
@@ -369,6 +436,9 @@ func ReadLocalPackageRecursive(repo *repo.Repo,
 		if LocalPackageSpecialName(name) || strings.HasPrefix(name, ".") {
 			continue
 		}
+		if repo.PathIgnored(basePath + "/" + pkgName + "/" + name) {
+			continue
+		}
 
 		if err := ReadLocalPackageRecursive(repo, pkgList, basePath,
 			pkgName+"/"+name); err != nil {
@@ -416,9 +486,13 @@ func ReadLocalPackages(repo *repo.Repo, basePath string,
 				continue
 			}
 
+			if repo.PathIgnored(pkgDir + "/" + name) {
+				continue
+			}
+
 			if err := ReadLocalPackageRecursive(repo, pkgList, pkgDir,
 				name); err != nil {
-				return nil, util.NewNewtError(err.Error())
+				return nil, err
 			}
 		}
 	}
@@ -0,0 +1,430 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/newt/schema"
+	"mynewt.apache.org/newt/util"
+)
+
+const pkgHeaderPrefix = "### Package: "
+const pkgNameKey = "pkg.name"
+
+// Matches the YAML scalars on a line: quoted strings and plain words.
+var yamlScalarRe = regexp.MustCompile(`"[^"]*"|'[^']*'|[^\s,\[\]{}"']+`)
+
+// A file rewritten by a package move.
+type MovedFile struct {
+	// Path before the move.
+	OldPath string
+
+	// Path after the move; differs from OldPath if the file is in one of the
+	// moved packages.
+	NewPath string
+
+	Contents []byte
+
+	// Contents before the move; restored if the move fails.
+	oldContents []byte
+}
+
+// The changes needed to move a package, and any packages nested inside it,
+// to a new location in the same repository.
+type PackageMove struct {
+	Repo    *repo.Repo
+	OldName string
+	NewName string
+
+	// Old package name to new package name, for every moved package.
+	Renames map[string]string
+
+	// Files containing references to moved packages, sorted by old path.
+	Files []*MovedFile
+}
+
+func (pm *PackageMove) oldDir() string {
+	return filepath.Join(pm.Repo.Path(), pm.OldName)
+}
+
+func (pm *PackageMove) newDir() string {
+	return filepath.Join(pm.Repo.Path(), pm.NewName)
+}
+
+// Returns the path a file ends up at after the move.
+func (pm *PackageMove) movedPath(path string) string {
+	rel, err := filepath.Rel(pm.oldDir(), path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.Join(pm.newDir(), rel)
+}
+
+// Rewrites a package string found in a file in the specified repository if it
+// refers to a moved package.  The string keeps its form: repo-qualified or
+// not, and any version requirements following the package name (e.g.,
+// "@apache-mynewt-core/sys/log >= 0.9") are kept.
+func (pm *PackageMove) rewriteRef(fileRepo interfaces.RepoInterface,
+	str string) (string, bool) {
+
+	m := depStringRe.FindStringSubmatchIndex(str)
+	if m == nil {
+		return str, false
+	}
+	prefix := str[:m[2]]
+	suffix := str[m[3]:]
+
+	repoName, pkgName, err := newtutil.ParsePackageString(str[m[2]:m[3]])
+	if err != nil {
+		return str, false
+	}
+
+	depRepo := repoName
+	if depRepo == "" {
+		depRepo = fileRepo.Name()
+	}
+	if depRepo != pm.Repo.Name() {
+		return str, false
+	}
+
+	newName, ok := pm.Renames[pkgName]
+	if !ok {
+		return str, false
+	}
+
+	return prefix + newtutil.BuildPackageString(repoName, newName) + suffix,
+		true
+}
+
+// Splits a line of YAML into its code and its comment.  A "#" starts a
+// comment only at the start of the line or after whitespace, and not inside
+// a quoted string.
+func splitComment(line string) (string, string) {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i], line[i:]
+		}
+	}
+
+	return line, ""
+}
+
+// Returns the indentation of a line of YAML.
+func lineIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// Determines which lines of a YAML file hold the values of settings that name
+// packages (e.g., pkg.deps, target.app), according to the file's schema.  A
+// value extends from its key's line over the lines which are indented
+// further, or which continue a list at the key's indentation.  The moved
+// packages' own pkg.name settings are included as well.
+func packageRefLines(path string, contents []byte,
+	s *schema.Schema) (map[int]bool, error) {
+
+	f, err := s.Validate(path, contents)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(contents), "\n")
+	refLines := map[int]bool{}
+	for _, setting := range f.Settings {
+		if setting.Key == nil || setting.Line <= 0 ||
+			(!setting.Key.PackageRef && setting.Name != pkgNameKey) {
+
+			continue
+		}
+
+		refLines[setting.Line] = true
+		indent := lineIndent(lines[setting.Line-1])
+		for i := setting.Line; i < len(lines); i++ {
+			code, _ := splitComment(lines[i])
+			trimmed := strings.TrimSpace(code)
+			if trimmed == "" {
+				continue
+			}
+
+			ind := lineIndent(code)
+			if ind < indent ||
+				(ind == indent && !strings.HasPrefix(trimmed, "-")) {
+
+				break
+			}
+			refLines[i+1] = true
+		}
+	}
+
+	return refLines, nil
+}
+
+// Rewrites each reference to a moved package on a line of YAML.  Only the
+// header comment that newt writes at the top of a pkg.yml file and, if
+// isRef is set, the values on the line are rewritten.
+func (pm *PackageMove) rewriteLine(fileRepo interfaces.RepoInterface,
+	line string, isRef bool) string {
+
+	// Leave comments alone, except for the header.
+	if strings.HasPrefix(line, pkgHeaderPrefix) {
+		name := strings.TrimPrefix(line, pkgHeaderPrefix)
+		if newName, ok := pm.rewriteRef(fileRepo, name); ok {
+			return pkgHeaderPrefix + newName
+		}
+		return line
+	}
+
+	if !isRef {
+		return line
+	}
+
+	code, comment := splitComment(line)
+
+	code = yamlScalarRe.ReplaceAllStringFunc(code, func(token string) string {
+		quote := ""
+		str := token
+		if len(token) >= 2 && (token[0] == '"' || token[0] == '\'') {
+			quote = token[:1]
+			str = token[1 : len(token)-1]
+		}
+
+		if newStr, ok := pm.rewriteRef(fileRepo, str); ok {
+			return quote + newStr + quote
+		}
+		return token
+	})
+
+	return code + comment
+}
+
+func (pm *PackageMove) rewriteFile(fileRepo interfaces.RepoInterface,
+	path string) error {
+
+	// Only files with a schema can name packages.
+	s := schema.PackageFileSchemas[filepath.Base(path)]
+	if s == nil {
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return util.NewNewtError(err.Error())
+	}
+
+	refLines, err := packageRefLines(path, contents, s)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(contents), "\n")
+	for i, line := range lines {
+		lines[i] = pm.rewriteLine(fileRepo, line, refLines[i+1])
+	}
+	newContents := []byte(strings.Join(lines, "\n"))
+
+	newPath := pm.movedPath(path)
+	if string(newContents) != string(contents) {
+		pm.Files = append(pm.Files, &MovedFile{
+			OldPath:     path,
+			NewPath:     newPath,
+			Contents:    newContents,
+			oldContents: contents,
+		})
+	}
+
+	return nil
+}
+
+// Returns the YAML files in the specified package directory.
+func packageYmlFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, util.NewNewtError(err.Error())
+	}
+
+	paths := []string{}
+	for _, info := range infos {
+		if info.Mode().IsRegular() && strings.HasSuffix(info.Name(), ".yml") {
+			paths = append(paths, filepath.Join(dir, info.Name()))
+		}
+	}
+
+	return paths, nil
+}
+
+// Determines the changes needed to move the specified package to a new
+// location in its repository.  Packages nested inside the package are moved
+// with it.  References to the moved packages in the YAML files (pkg.yml,
+// target.yml, etc.) of every package in the local repository and in the
+// package's own repository are rewritten, including each moved package's
+// pkg.name.
+func PlanPackageMove(lpkg *LocalPackage, newName string) (*PackageMove,
+	error) {
+
+	r := lpkg.repo
+	if !r.IsLocal() && !r.InPlace() {
+		return nil, util.NewNewtError(fmt.Sprintf("Cannot move package %s; "+
+			"repository %s is installed by newt", lpkg.FullName(), r.Name()))
+	}
+
+	pm := &PackageMove{
+		Repo:    r,
+		OldName: lpkg.Name(),
+		NewName: newName,
+		Renames: map[string]string{},
+	}
+
+	if filepath.Clean(pm.oldDir()) != filepath.Clean(lpkg.BasePath()) {
+		return nil, util.NewNewtError(fmt.Sprintf("Cannot move package %s; "+
+			"its name does not match its location (%s)", lpkg.FullName(),
+			lpkg.BasePath()))
+	}
+	if util.NodeExist(pm.newDir()) {
+		return nil, util.NewNewtError(fmt.Sprintf("Cannot move package %s "+
+			"to %s; %s already exists", pm.OldName, newName, pm.newDir()))
+	}
+	if strings.HasPrefix(newName+"/", pm.OldName+"/") {
+		return nil, util.NewNewtError(fmt.Sprintf("Cannot move package %s "+
+			"into itself", pm.OldName))
+	}
+
+	pkgList := interfaces.GetProject().PackageList()
+
+	// Find the moved packages: the package itself, and any packages in its
+	// directory.
+	for _, pack := range *pkgList[r.Name()] {
+		name := pack.Name()
+		if name == pm.OldName {
+			pm.Renames[name] = newName
+		} else if strings.HasPrefix(name, pm.OldName+"/") {
+			pm.Renames[name] = newName + strings.TrimPrefix(name, pm.OldName)
+		} else if name == newName {
+			return nil, util.NewNewtError(fmt.Sprintf("Package %s already "+
+				"exists", newName))
+		}
+	}
+
+	repoNames := []string{repo.REPO_NAME_LOCAL}
+	if r.Name() != repo.REPO_NAME_LOCAL {
+		repoNames = append(repoNames, r.Name())
+	}
+
+	// Collect the files to scan, sorted, so that changes are reported in a
+	// consistent order.
+	fileRepos := map[string]interfaces.RepoInterface{}
+	for _, repoName := range repoNames {
+		packs := pkgList[repoName]
+		if packs == nil {
+			continue
+		}
+
+		for _, pack := range *packs {
+			lp := pack.(*LocalPackage)
+			paths, err := packageYmlFiles(lp.BasePath())
+			if err != nil {
+				return nil, err
+			}
+
+			for _, path := range paths {
+				fileRepos[path] = lp.Repo()
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(fileRepos))
+	for path, _ := range fileRepos {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if err := pm.rewriteFile(fileRepos[path], path); err != nil {
+			return nil, err
+		}
+	}
+
+	return pm, nil
+}
+
+// Writes the specified contents to each file, at its path before the move.
+// On failure, the files already written are restored.
+func writeMovedFiles(files []*MovedFile, newContents bool) error {
+	for i, f := range files {
+		contents := f.oldContents
+		if newContents {
+			contents = f.Contents
+		}
+
+		info, err := os.Stat(f.OldPath)
+		if err == nil {
+			err = ioutil.WriteFile(f.OldPath, contents, info.Mode().Perm())
+		}
+		if err != nil {
+			if newContents {
+				writeMovedFiles(files[:i], false)
+			}
+			return util.NewNewtError(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Writes the rewritten files and moves the package directory.  If the
+// directory cannot be moved, the files are restored.
+func (pm *PackageMove) Apply() error {
+	if err := writeMovedFiles(pm.Files, true); err != nil {
+		return err
+	}
+
+	err := os.MkdirAll(filepath.Dir(pm.newDir()), 0755)
+	if err == nil {
+		log.Debugf("Moving %s to %s", pm.oldDir(), pm.newDir())
+		err = os.Rename(pm.oldDir(), pm.newDir())
+	}
+	if err != nil {
+		if restoreErr := writeMovedFiles(pm.Files, false); restoreErr != nil {
+			log.Debugf("Failed to restore files: %s", restoreErr.Error())
+		}
+		return util.NewNewtError(err.Error())
+	}
+
+	return nil
+}
@@ -65,6 +65,8 @@ type Package interface {
 	FullName() string
 	// The type of package (lib, target, bsp, etc.)
 	Type() interfaces.PackageType
+	// The version declared by the package (pkg.version); nil if none
+	Version() interfaces.VersionInterface
 	// Hash of the contents of the package
 	Hash() (string, error)
 	// Description of this package
@@ -0,0 +1,359 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
+)
+
+// Directory, relative to the project base, containing a project's package
+// templates.  A template for a package type is a directory named after the
+// type (e.g., templates/pkg/lib/), and replaces the built-in template.
+const PACKAGE_TEMPLATE_DIR = "templates/pkg"
+
+// Template variables.  Occurrences of {{<name>}} in a template's file names
+// and contents are replaced with the variable's value.
+const (
+	PACKAGE_TEMPLATE_VAR_NAME     = "pkg.name"
+	PACKAGE_TEMPLATE_VAR_BASENAME = "pkg.basename"
+	PACKAGE_TEMPLATE_VAR_TYPE     = "pkg.type"
+	PACKAGE_TEMPLATE_VAR_IDENT    = "pkg.ident"
+	PACKAGE_TEMPLATE_VAR_IDENT_UC = "pkg.IDENT"
+)
+
+const pkgTemplateHeader = `### Package: {{pkg.name}}
+pkg.name: "{{pkg.name}}"
+pkg.type: "{{pkg.type}}"
+pkg.description:
+pkg.author:
+pkg.homepage:
+`
+
+const libTemplateHeader = `#ifndef H_{{pkg.IDENT}}_
+#define H_{{pkg.IDENT}}_
+
+void {{pkg.ident}}_init(void);
+
+#endif
+`
+
+const libTemplateSrc = `#include "{{pkg.basename}}/{{pkg.basename}}.h"
+
+void
+{{pkg.ident}}_init(void)
+{
+}
+`
+
+// The lib's init function is called by the generated sysinit().
+const libTemplatePkg = pkgTemplateHeader + `pkg.init:
+    {{pkg.ident}}_init: 500
+`
+
+const appTemplatePkg = pkgTemplateHeader + `
+pkg.deps:
+    - "@apache-mynewt-core/libs/os"
+`
+
+const appTemplateSrc = `#include "os/os.h"
+#include "sysinit/sysinit.h"
+
+int
+main(int argc, char **argv)
+{
+    os_init();
+
+    /* Initialize all packages. */
+    sysinit();
+
+    os_start();
+
+    /* os_start() never returns. */
+    return 0;
+}
+`
+
+const bspTemplatePkg = pkgTemplateHeader + `pkg.arch:
+pkg.compiler:
+pkg.linkerscript: "{{pkg.basename}}.ld"
+pkg.downloadscript: "{{pkg.basename}}_download.sh"
+pkg.debugscript: "{{pkg.basename}}_debug.sh"
+`
+
+const bspTemplateHeader = `#ifndef H_BSP_
+#define H_BSP_
+
+/* Define the board's LEDs, UARTs, and other peripherals here. */
+
+#endif
+`
+
+const bspTemplateSrc = `#include "bsp/bsp.h"
+
+void
+bsp_init(void)
+{
+}
+`
+
+const bspTemplateLinker = `/* Linker script for {{pkg.name}}; adjust the memory regions for the MCU. */
+
+MEMORY
+{
+    FLASH (rx) : ORIGIN = 0x00000000, LENGTH = 0x00000000
+    RAM (rwx) : ORIGIN = 0x00000000, LENGTH = 0x00000000
+}
+
+SECTIONS
+{
+    .text :
+    {
+        KEEP(*(.isr_vector))
+        *(.text*)
+        *(.rodata*)
+    } > FLASH
+
+    .data :
+    {
+        *(.data*)
+    } > RAM AT > FLASH
+
+    .bss :
+    {
+        *(.bss*)
+        *(COMMON)
+    } > RAM
+}
+`
+
+const bspTemplateDownload = `#!/bin/sh
+# Downloads an image to a {{pkg.basename}} board.
+#  - $1 is the path of the image to download, without the extension.
+echo "Download for {{pkg.name}} is not implemented"
+exit 1
+`
+
+const bspTemplateDebug = `#!/bin/sh
+# Starts a debug session on a {{pkg.basename}} board.
+#  - $1 is the path of the image to debug, without the extension.
+echo "Debugging for {{pkg.name}} is not implemented"
+exit 1
+`
+
+const compilerTemplateYml = `# Compiler definition for {{pkg.name}}.
+
+compiler.path.cc: "gcc"
+compiler.path.as: "gcc"
+compiler.path.archive: "ar"
+compiler.path.objdump: "objdump"
+compiler.path.objsize: "size"
+compiler.path.objcopy: "objcopy"
+
+compiler.flags.base: -Wall -Werror
+compiler.flags.default: [compiler.flags.base, -O1, -ggdb]
+compiler.flags.optimized: [compiler.flags.base, -Os, -ggdb]
+compiler.flags.debug: [compiler.flags.base, -O0, -ggdb]
+
+compiler.as.flags: [-x, assembler-with-cpp]
+
+compiler.ld.flags:
+compiler.ld.resolve_circular_deps: true
+compiler.ld.mapfile: true
+`
+
+// Built-in package templates: file path to contents, for each type of
+// package that can be created.
+var packageTemplates = map[interfaces.PackageType]map[string]string{
+	PACKAGE_TYPE_APP: {
+		PACKAGE_FILE_NAME: appTemplatePkg,
+		"src/main.c":      appTemplateSrc,
+	},
+	PACKAGE_TYPE_BSP: {
+		PACKAGE_FILE_NAME:              bspTemplatePkg,
+		"include/bsp/bsp.h":            bspTemplateHeader,
+		"src/hal_bsp.c":                bspTemplateSrc,
+		"{{pkg.basename}}.ld":          bspTemplateLinker,
+		"{{pkg.basename}}_download.sh": bspTemplateDownload,
+		"{{pkg.basename}}_debug.sh":    bspTemplateDebug,
+	},
+	PACKAGE_TYPE_COMPILER: {
+		PACKAGE_FILE_NAME: pkgTemplateHeader,
+		"compiler.yml":    compilerTemplateYml,
+	},
+	PACKAGE_TYPE_LIB: {
+		PACKAGE_FILE_NAME: libTemplatePkg,
+		"include/{{pkg.basename}}/{{pkg.basename}}.h": libTemplateHeader,
+		"src/{{pkg.basename}}.c":                      libTemplateSrc,
+	},
+}
+
+var identRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Returns the names of the package types which can be created, sorted.
+func ScaffoldTypeNames() []string {
+	names := []string{}
+	for pkgType, _ := range packageTemplates {
+		names = append(names, PackageTypeNames[pkgType])
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the package type with the specified name.
+func PackageTypeFromString(typeName string) (interfaces.PackageType, error) {
+	for t, n := range PackageTypeNames {
+		if n == typeName {
+			return t, nil
+		}
+	}
+
+	return 0, util.NewNewtError(fmt.Sprintf("Invalid package type: %s; "+
+		"must be one of: %s", typeName,
+		strings.Join(ScaffoldTypeNames(), ", ")))
+}
+
+// Reads the project's template for the specified package type, if it has
+// one.
+func readProjectTemplate(dir string) (map[string]string, error) {
+	files := map[string]string{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo,
+		err error) error {
+
+		if err != nil {
+			return util.NewNewtError(err.Error())
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return util.NewNewtError(err.Error())
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return util.NewNewtError(err.Error())
+		}
+		files[rel] = string(contents)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Returns the template for the specified package type: the project's
+// template if there is one, otherwise the built-in template.
+func packageTemplate(pkgType interfaces.PackageType) (map[string]string,
+	error) {
+
+	typeName := PackageTypeNames[pkgType]
+	dir := filepath.Join(interfaces.GetProject().Path(),
+		PACKAGE_TEMPLATE_DIR, typeName)
+	if util.NodeExist(dir) {
+		log.Debugf("Using package template in %s", dir)
+		return readProjectTemplate(dir)
+	}
+
+	files, ok := packageTemplates[pkgType]
+	if !ok {
+		return nil, util.NewNewtError(fmt.Sprintf("Cannot create package "+
+			"of type %s; must be one of: %s", typeName,
+			strings.Join(ScaffoldTypeNames(), ", ")))
+	}
+
+	return files, nil
+}
+
+// Creates a package of the specified type in the specified repository,
+// writing the files of the type's template.  Returns the paths of the
+// created files, sorted.
+func CreatePackage(r *repo.Repo, pkgName string,
+	pkgType interfaces.PackageType) ([]string, error) {
+
+	files, err := packageTemplate(pkgType)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgDir := filepath.Join(r.Path(), pkgName)
+	if util.NodeExist(pkgDir) {
+		return nil, util.NewNewtError(fmt.Sprintf("Cannot create package "+
+			"%s; %s already exists", pkgName, pkgDir))
+	}
+
+	basename := filepath.Base(pkgName)
+	ident := identRe.ReplaceAllString(basename, "_")
+	vars := map[string]string{
+		PACKAGE_TEMPLATE_VAR_NAME:     pkgName,
+		PACKAGE_TEMPLATE_VAR_BASENAME: basename,
+		PACKAGE_TEMPLATE_VAR_TYPE:     PackageTypeNames[pkgType],
+		PACKAGE_TEMPLATE_VAR_IDENT:    ident,
+		PACKAGE_TEMPLATE_VAR_IDENT_UC: strings.ToUpper(ident),
+	}
+
+	paths := []string{}
+	for name, contents := range files {
+		path := filepath.Join(pkgDir,
+			newtutil.ExpandTemplateVars(name, vars))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			os.RemoveAll(pkgDir)
+			return nil, util.NewNewtError(err.Error())
+		}
+
+		// Scripts need to be executable.
+		perm := os.FileMode(0644)
+		if strings.HasSuffix(path, ".sh") {
+			perm = 0755
+		}
+
+		log.Debugf("Creating %s", path)
+		if err := ioutil.WriteFile(path,
+			[]byte(newtutil.ExpandTemplateVars(contents, vars)),
+			perm); err != nil {
+
+			os.RemoveAll(pkgDir)
+			return nil, util.NewNewtError(err.Error())
+		}
+
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
@@ -0,0 +1,233 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package project
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/schema"
+	"mynewt.apache.org/newt/util"
+)
+
+// Features which newt enables itself, rather than a package.
+var builtinFeatures = []string{"TEST", "SELFTEST"}
+
+// Prefixes of the features which newt enables itself (e.g., ARCH_cortex_m4
+// for a BSP's architecture).
+var builtinFeaturePrefixes = []string{"ARCH_"}
+
+func isDeclaredFeature(feature string, features map[string]bool) bool {
+	feature = strings.ToUpper(feature)
+	for _, prefix := range builtinFeaturePrefixes {
+		if strings.HasPrefix(feature, prefix) {
+			return true
+		}
+	}
+	return features[feature]
+}
+
+// The YAML files in a package which newt lint checks, and their schemas.
+var lintFileSchemas = []struct {
+	name   string
+	schema *schema.Schema
+}{
+	{pkg.PACKAGE_FILE_NAME, schema.PackageSchema},
+	{"target.yml", schema.TargetSchema},
+	{"compiler.yml", schema.CompilerSchema},
+}
+
+// Returns the packages in the local repository and in repositories used in
+// place, i.e., the packages that belong to the user rather than to an
+// installed repository, sorted by name.
+func (proj *Project) lintPackages() []*pkg.LocalPackage {
+	packMap := map[string]*pkg.LocalPackage{}
+	for rname, packs := range proj.packages {
+		r := proj.repos[rname]
+		if r == nil || (!r.IsLocal() && !r.InPlace()) {
+			continue
+		}
+		for _, pack := range *packs {
+			lpkg := pack.(*pkg.LocalPackage)
+			packMap[lpkg.FullName()] = lpkg
+		}
+	}
+
+	names := make([]string, 0, len(packMap))
+	for name, _ := range packMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lpkgs := make([]*pkg.LocalPackage, len(names))
+	for i, name := range names {
+		lpkgs[i] = packMap[name]
+	}
+	return lpkgs
+}
+
+// Returns the features declared by any package in the project, in upper
+// case.
+func (proj *Project) declaredFeatures() (map[string]bool, error) {
+	features := map[string]bool{}
+	for _, feature := range builtinFeatures {
+		features[feature] = true
+	}
+
+	for _, packs := range proj.packages {
+		for _, pack := range *packs {
+			lpkg := pack.(*pkg.LocalPackage)
+			f, err := schema.PackageSchema.ValidateFile(
+				filepath.Join(lpkg.BasePath(), pkg.PACKAGE_FILE_NAME))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, s := range f.Settings {
+				if s.Key == nil || s.Key.Name != "pkg.features" {
+					continue
+				}
+				for _, feature := range s.Strings() {
+					features[strings.ToUpper(feature)] = true
+				}
+			}
+		}
+	}
+
+	return features, nil
+}
+
+// Checks the references to other packages and the features in a file.
+func (proj *Project) lintReferences(lpkg *pkg.LocalPackage, f *schema.File,
+	features map[string]bool) {
+
+	for _, s := range f.Settings {
+		if s.Key == nil {
+			continue
+		}
+
+		if s.Feature != "" {
+			// An invalid expression has already been reported.
+			expr, err := newtutil.ParseFeatureCondition(s.Feature)
+			if err == nil {
+				for _, feature := range expr.Features() {
+					if !isDeclaredFeature(feature, features) {
+						f.AddProblem(s, "%s is conditioned on undeclared "+
+							"feature %s", s.Name, feature)
+					}
+				}
+			}
+		}
+
+		if !s.Key.PackageRef {
+			continue
+		}
+		for _, name := range s.Strings() {
+			dep, err := pkg.NewDependency(lpkg.Repo(), name)
+			if err != nil {
+				f.AddProblem(s, "invalid package name in %s: %s", s.Name,
+					err.(*util.NewtError).Text)
+				continue
+			}
+
+			// Distinguish a missing package from a version mismatch.
+			anyVers := *dep
+			anyVers.VersionReqs = nil
+			if proj.ResolveDependency(&anyVers) == nil {
+				f.AddProblem(s, "%s refers to unknown package %s", s.Name,
+					name)
+				continue
+			}
+			_, err = proj.ResolveDependencyVersion(dep)
+			if err != nil {
+				f.AddProblem(s, "%s: %s", s.Name, err.(*util.NewtError).Text)
+			}
+		}
+	}
+}
+
+// Checks a package's YAML files against their schemas, and checks that the
+// packages and features they refer to exist.
+func (proj *Project) lintPackage(lpkg *pkg.LocalPackage,
+	features map[string]bool) ([]*schema.Problem, error) {
+
+	problems := []*schema.Problem{}
+	for _, lf := range lintFileSchemas {
+		path := filepath.Join(lpkg.BasePath(), lf.name)
+		if util.NodeNotExist(path) {
+			continue
+		}
+
+		f, err := lf.schema.ValidateFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// Compiler settings are conditioned on build profiles and operating
+		// systems, not on features.
+		if lf.schema != schema.CompilerSchema {
+			proj.lintReferences(lpkg, f, features)
+			f.SortProblems()
+		}
+
+		problems = append(problems, f.Problems...)
+	}
+
+	return problems, nil
+}
+
+// Checks the project's YAML files: project.yml and the files in each of the
+// specified packages.  If no packages are specified, the packages in the
+// local repository and in repositories used in place are checked.  Returns
+// the problems found, grouped by file.
+func (proj *Project) Lint(lpkgs []*pkg.LocalPackage) ([]*schema.Problem,
+	error) {
+
+	problems := []*schema.Problem{}
+
+	if len(lpkgs) == 0 {
+		f, err := schema.ProjectSchema.ValidateFile(proj.BasePath + "/" +
+			PROJECT_FILE_NAME)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, f.Problems...)
+
+		lpkgs = proj.lintPackages()
+	}
+
+	features, err := proj.declaredFeatures()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lpkg := range lpkgs {
+		pkgProblems, err := proj.lintPackage(lpkg, features)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, pkgProblems...)
+	}
+
+	return problems, nil
+}
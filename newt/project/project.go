@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sort"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -32,6 +33,7 @@ import (
 	"mynewt.apache.org/newt/newt/interfaces"
 	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/newt/schema"
 	"mynewt.apache.org/newt/util"
 	"mynewt.apache.org/newt/viper"
 )
@@ -67,6 +69,9 @@ type Project struct {
 
 	projState *ProjectState
 
+	// Exact commits of the installed repositories
+	projLock *ProjectLock
+
 	// Repositories configured on this project
 	repos map[string]*repo.Repo
 
@@ -158,6 +163,13 @@ func (proj *Project) PackageSearchDirs() []string {
 	return proj.packageSearchDirs
 }
 
+// Returns the directories of the specified repository to search for
+// packages: those declared in the repository's repository.yml, or the
+// project's search directories if it declares none.
+func (proj *Project) RepoPackageSearchDirs(r *repo.Repo) ([]string, error) {
+	return r.PackageDirs(proj.packageSearchDirs)
+}
+
 func (proj *Project) upgradeCheck(r *repo.Repo, vers *repo.Version,
 	force bool) (bool, error) {
 	rdesc, err := r.GetRepoDesc()
@@ -270,6 +282,9 @@ func (proj *Project) checkDeps(r *repo.Repo) error {
 		if !ok {
 			proj.repos[newRepo.Name()] = newRepo
 			return proj.UpdateRepos()
+		} else if curRepo.InPlace() {
+			// The project's local copy overrides the dependency.
+			continue
 		} else {
 			// Add any dependencies we might have found here.
 			for _, dep := range newRepo.Deps() {
@@ -286,7 +301,7 @@ func (proj *Project) checkDeps(r *repo.Repo) error {
 func (proj *Project) UpdateRepos() error {
 	repoList := proj.Repos()
 	for rname, r := range repoList {
-		if rname == repo.REPO_NAME_LOCAL {
+		if rname == repo.REPO_NAME_LOCAL || r.InPlace() {
 			continue
 		}
 
@@ -298,7 +313,199 @@ func (proj *Project) UpdateRepos() error {
 	return nil
 }
 
-func (proj *Project) Install(upgrade bool, force bool) error {
+// Mirrors each of the project's repositories, including those it depends on
+// indirectly, into the repo cache, such that they can be installed offline.
+func (proj *Project) Mirror() error {
+	if err := proj.UpdateRepos(); err != nil {
+		return err
+	}
+
+	rnames := []string{}
+	for rname, r := range proj.Repos() {
+		if rname != repo.REPO_NAME_LOCAL && !r.InPlace() {
+			rnames = append(rnames, rname)
+		}
+	}
+	sort.Strings(rnames)
+
+	for _, rname := range rnames {
+		r := proj.repos[rname]
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Mirroring %s (%s)\n",
+			rname, r.Url())
+		if err := r.Mirror(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Downloads the description of a repository discovered by the dependency
+// solver.
+func (proj *Project) loadDepRepo(r *repo.Repo) (*repo.Repo, error) {
+	if _, _, err := r.UpdateDesc(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Selects the version of each repository to install, and drops any
+// repositories which the selected versions don't require.
+func (proj *Project) resolveRepos() error {
+	solver := repo.NewRepoSolver(proj.repos, proj.loadDepRepo)
+	selected, err := solver.Solve(proj.localRepo.Deps())
+	if err != nil {
+		return err
+	}
+
+	for rname, r := range proj.repos {
+		if rname == repo.REPO_NAME_LOCAL || r.InPlace() {
+			continue
+		}
+		if selected[rname] == nil {
+			log.Debugf("Repository %s is not required", rname)
+			delete(proj.repos, rname)
+		}
+	}
+
+	return nil
+}
+
+// Records the installed state of a repository in the project lock.
+func (proj *Project) lockRepo(r *repo.Repo, vers *repo.Version) error {
+	commit, err := r.HeadCommit()
+	if err != nil {
+		return err
+	}
+
+	proj.projLock.Replace(&LockedRepo{
+		Name:    r.Name(),
+		Version: LockVersionString(vers),
+		Branch:  r.VersionBranch(vers),
+		Commit:  commit,
+		Url:     r.Url(),
+	})
+
+	return nil
+}
+
+// Verifies that the project lock covers exactly the repositories required
+// by the project.
+func (proj *Project) checkFrozenLock() error {
+	if !proj.projLock.Exists() {
+		return util.NewNewtError(fmt.Sprintf("Cannot perform frozen "+
+			"install; %s does not exist", PROJECT_LOCK_FILE))
+	}
+
+	for rname, r := range proj.Repos() {
+		if rname == repo.REPO_NAME_LOCAL || r.InPlace() {
+			continue
+		}
+		if proj.projLock.Get(rname) == nil {
+			return util.NewNewtError(fmt.Sprintf("Repository %s is not "+
+				"in %s; run \"newt upgrade\" to update it", rname,
+				PROJECT_LOCK_FILE))
+		}
+	}
+
+	for _, rname := range proj.projLock.RepoNames() {
+		r := proj.repos[rname]
+		if r == nil || r.InPlace() {
+			return util.NewNewtError(fmt.Sprintf("Repository %s in %s is "+
+				"not required by the project; run \"newt upgrade\" to "+
+				"update it", rname, PROJECT_LOCK_FILE))
+		}
+	}
+
+	return nil
+}
+
+// Installs the exact commit of a repository recorded in the project lock.
+func (proj *Project) installLocked(r *repo.Repo, locked *LockedRepo,
+	force bool, frozen bool) error {
+
+	lockVers, err := locked.LoadVersion()
+	if err != nil {
+		return err
+	}
+
+	rdesc, err := r.GetRepoDesc()
+	if err != nil {
+		return err
+	}
+
+	if !rdesc.SatisfiesVersion(lockVers, r.VersionRequirements()) {
+		if frozen {
+			return util.NewNewtError(fmt.Sprintf("Locked version %s of "+
+				"repository %s does not match desired version %s in "+
+				"project file", locked.Version, r.Name(),
+				r.VersionRequirementsString()))
+		}
+		util.StatusMessage(util.VERBOSITY_QUIET, "WARNING: Locked "+
+			"version %s of repository %s does not match desired version "+
+			"%s in project file.  Run \"newt upgrade\" to update %s.\n",
+			locked.Version, r.Name(), r.VersionRequirementsString(),
+			PROJECT_LOCK_FILE)
+	}
+
+	// An installed repository at another commit is brought to the locked
+	// commit; local modifications still require -f.  A frozen install
+	// refuses to change it.
+	installed := proj.projState.GetInstalledVersion(r.Name()) != nil &&
+		util.NodeExist(r.Path())
+	if installed {
+		// A repository which isn't a git checkout is replaced.
+		commit, err := r.HeadCommit()
+		if err == nil && commit == locked.Commit && !force {
+			util.StatusMessage(util.VERBOSITY_VERBOSE, "%s locked commit "+
+				"already installed\n", r.Name())
+			return nil
+		}
+		if frozen {
+			if err != nil {
+				return err
+			}
+			if commit != locked.Commit {
+				return util.NewNewtError(fmt.Sprintf("Installed "+
+					"repository %s is at commit %s, not commit %s in %s",
+					r.Name(), commit, locked.Commit, PROJECT_LOCK_FILE))
+			}
+		}
+	}
+
+	if err := r.InstallCommit(installed || force, force,
+		locked.Commit); err != nil {
+
+		return err
+	}
+
+	// Make sure the source actually provided the locked commit.
+	commit, err := r.HeadCommit()
+	if err != nil {
+		return err
+	}
+	if commit != locked.Commit {
+		return util.NewNewtError(fmt.Sprintf("Installed repository %s is "+
+			"at commit %s, not commit %s in %s; the repository or its "+
+			"mirror may have been tampered with", r.Name(), commit,
+			locked.Commit, PROJECT_LOCK_FILE))
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "%s successfully installed "+
+		"locked version %s (commit %s)\n", r.Name(), locked.Version,
+		locked.Commit)
+
+	proj.projState.Replace(r.Name(), lockVers)
+	return nil
+}
+
+// Installs or upgrades the project's repositories.  An install reproduces
+// the commits recorded in the project lock, and only adds repositories which
+// are missing from it; an upgrade resolves every repository against
+// project.yml and rewrites the lock.  A frozen install fails if the lock and
+// project.yml disagree, and never modifies the lock.
+func (proj *Project) Install(upgrade bool, force bool, frozen bool) error {
 	repoList := proj.Repos()
 
 	for rname, _ := range repoList {
@@ -314,15 +521,36 @@ func (proj *Project) Install(upgrade bool, force bool) error {
 		}
 	}
 
-	// Get repository list, and print every repo and it's dependencies.
-	if err := repo.CheckDeps(upgrade, proj.Repos()); err != nil {
+	// Select a version of each repository that satisfies every requirement.
+	if err := proj.resolveRepos(); err != nil {
 		return err
 	}
 
+	if frozen {
+		if err := proj.checkFrozenLock(); err != nil {
+			return err
+		}
+	}
+
 	for rname, r := range proj.Repos() {
 		if rname == repo.REPO_NAME_LOCAL {
 			continue
 		}
+		if r.InPlace() {
+			util.StatusMessage(util.VERBOSITY_VERBOSE, "Using repository %s "+
+				"in place at %s\n", r.Name(), r.Path())
+			continue
+		}
+
+		if locked := proj.projLock.Get(rname); locked != nil && !upgrade {
+			if err := proj.installLocked(r, locked, force,
+				frozen); err != nil {
+
+				return err
+			}
+			continue
+		}
+
 		// Check the version requirements on this repository, and see
 		// whether or not we need to install/upgrade it.
 		skip, err := proj.checkVersionRequirements(r, upgrade, force)
@@ -330,11 +558,21 @@ func (proj *Project) Install(upgrade bool, force bool) error {
 			return err
 		}
 		if skip {
+			// Lock the repository at its current state; it may have been
+			// installed before the lock file existed.
+			vers := proj.projState.GetInstalledVersion(rname)
+			if vers != nil && util.NodeExist(r.Path()) {
+				if err := proj.lockRepo(r, vers); err != nil {
+					util.StatusMessage(util.VERBOSITY_QUIET, "WARNING: "+
+						"Cannot lock repository %s: %s\n", rname,
+						err.Error())
+				}
+			}
 			continue
 		}
 
 		// Do the hard work of actually copying and installing the repository.
-		rvers, err := r.Install(upgrade || force)
+		rvers, err := r.Install(upgrade, force)
 		if err != nil {
 			return err
 		}
@@ -349,6 +587,10 @@ func (proj *Project) Install(upgrade bool, force bool) error {
 
 		// Update the project state with the new repository version information.
 		proj.projState.Replace(rname, rvers)
+
+		if err := proj.lockRepo(r, rvers); err != nil {
+			return err
+		}
 	}
 
 	// Save the project state, including any updates or changes to the project
@@ -357,11 +599,26 @@ func (proj *Project) Install(upgrade bool, force bool) error {
 		return err
 	}
 
+	// Only an upgrade removes repositories that are no longer required.
+	if upgrade {
+		for _, rname := range proj.projLock.RepoNames() {
+			if r := proj.repos[rname]; r == nil || r.InPlace() {
+				proj.projLock.Remove(rname)
+			}
+		}
+	}
+
+	if proj.projLock.Dirty() && !frozen {
+		if err := proj.projLock.Save(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (proj *Project) Upgrade(force bool) error {
-	return proj.Install(true, force)
+	return proj.Install(true, force, false)
 }
 
 func (proj *Project) loadRepo(rname string, v *viper.Viper) error {
@@ -372,23 +629,22 @@ func (proj *Project) loadRepo(rname string, v *viper.Viper) error {
 		return util.NewNewtError(fmt.Sprintf("Missing configuration for "+
 			"repository %s.", rname))
 	}
-	if repoVars["type"] == "" {
-		return util.NewNewtError(fmt.Sprintf("Missing type for repository " +
-			rname))
-	}
-	if repoVars["type"] != "github" {
-		return util.NewNewtError("Only github repositories are currently supported.")
+	if repoVars["type"] == "local" {
+		return proj.loadInPlaceRepo(rname, repoVars)
 	}
+
 	rversreq := repoVars["vers"]
 
-	dl := downloader.NewGithubDownloader()
-	dl.User = repoVars["user"]
-	dl.Repo = repoVars["repo"]
+	dl, err := downloader.LoadDownloader(rname, repoVars)
+	if err != nil {
+		return err
+	}
 
 	r, err := repo.NewRepo(rname, rversreq, dl)
 	if err != nil {
 		return err
 	}
+	r.SetDescHash(repoVars["desc_sha256"])
 
 	rd, err := repo.NewRepoDependency(rname, rversreq)
 	if err != nil {
@@ -398,8 +654,31 @@ func (proj *Project) loadRepo(rname string, v *viper.Viper) error {
 
 	proj.localRepo.AddDependency(rd)
 
-	log.Debugf("Loaded repository %s (type: %s, user: %s, repo: %s)", rname,
-		repoVars["type"], repoVars["user"], repoVars["repo"])
+	log.Debugf("Loaded repository %s (type: %s, user: %s, repo: %s, "+
+		"url: %s)", rname, repoVars["type"], repoVars["user"],
+		repoVars["repo"], repoVars["url"])
+
+	proj.repos[r.Name()] = r
+	return nil
+}
+
+// Loads a repository of type local, which is used directly from the specified
+// path rather than downloaded, similar to a Go "replace" directive.
+func (proj *Project) loadInPlaceRepo(rname string,
+	repoVars map[string]string) error {
+
+	if repoVars["path"] == "" {
+		return util.NewNewtError(fmt.Sprintf("Missing path for repository "+
+			"%s", rname))
+	}
+
+	r, err := repo.NewInPlaceRepo(rname, repoVars["path"])
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Loaded repository %s (type: local, path: %s)", rname,
+		r.Path())
 
 	proj.repos[r.Name()] = r
 	return nil
@@ -416,11 +695,25 @@ func (proj *Project) loadConfig() error {
 	// we need to process it later.
 	proj.v = v
 
+	f, err := schema.ProjectSchema.ValidateFile(proj.BasePath + "/" +
+		PROJECT_FILE_NAME)
+	if err != nil {
+		return err
+	}
+	if err := f.FatalError(); err != nil {
+		return err
+	}
+
 	proj.projState, err = LoadProjectState()
 	if err != nil {
 		return err
 	}
 
+	proj.projLock, err = LoadProjectLock()
+	if err != nil {
+		return err
+	}
+
 	proj.name = v.GetString("project.name")
 
 	// Local repository always included in initialization
@@ -475,6 +768,37 @@ func (proj *Project) ResolveDependency(dep interfaces.DependencyInterface) inter
 	return nil
 }
 
+// Returns the package which satisfies the specified dependency.  If there is
+// none, the error says why: no package has the dependency's name, or the
+// package's version does not meet the dependency's version requirements.
+func (proj *Project) ResolveDependencyVersion(
+	dep *pkg.Dependency) (*pkg.LocalPackage, error) {
+
+	if pack := proj.ResolveDependency(dep); pack != nil {
+		return pack.(*pkg.LocalPackage), nil
+	}
+
+	for _, pkgList := range proj.packages {
+		for _, pack := range *pkgList {
+			if !dep.SatisfiesName(pack) {
+				continue
+			}
+
+			if pack.Version() == nil {
+				return nil, util.FmtNewtError("Package %s does not declare "+
+					"a version (pkg.version); required: %s",
+					pack.FullName(), dep.VersionReqsString())
+			}
+			return nil, util.FmtNewtError("Package %s version %s does not "+
+				"satisfy requirement: %s", pack.FullName(),
+				pack.Version().String(), dep.VersionReqsString())
+		}
+	}
+
+	return nil, util.NewNewtError("Could not resolve package dependency " +
+		dep.String())
+}
+
 func findProjectDir(dir string) (string, error) {
 	for {
 		projFile := path.Clean(dir) + "/" + PROJECT_FILE_NAME
@@ -503,8 +827,12 @@ func (proj *Project) loadPackageList() error {
 	repos := proj.Repos()
 	for name, repo := range repos {
 		log.Debugf("Loading packages in repository %s", repo.Path())
-		list, err := pkg.ReadLocalPackages(repo, repo.Path(),
-			proj.PackageSearchDirs())
+		searchDirs, err := proj.RepoPackageSearchDirs(repo)
+		if err != nil {
+			return err
+		}
+
+		list, err := pkg.ReadLocalPackages(repo, repo.Path(), searchDirs)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,176 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package project
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/viper"
+	"mynewt.apache.org/newt/yaml"
+)
+
+const PROJECT_LOCK_FILE = "project.lock"
+
+// The exact state of an installed repository.
+type LockedRepo struct {
+	Name    string
+	Version string
+	Branch  string
+	Commit  string
+	Url     string
+}
+
+type ProjectLock struct {
+	repos map[string]*LockedRepo
+
+	// Whether the lock has changed since it was loaded.
+	dirty bool
+}
+
+// Formats a version such that it can be read back with repo.LoadVersion.
+func LockVersionString(vers *repo.Version) string {
+	return vers.String()
+}
+
+func (lr *LockedRepo) LoadVersion() (*repo.Version, error) {
+	return repo.LoadVersion(lr.Version)
+}
+
+func (pl *ProjectLock) LockFile() string {
+	return interfaces.GetProject().Path() + "/" + PROJECT_LOCK_FILE
+}
+
+func (pl *ProjectLock) Exists() bool {
+	return util.NodeExist(pl.LockFile())
+}
+
+func (pl *ProjectLock) Get(rname string) *LockedRepo {
+	lr, _ := pl.repos[rname]
+	return lr
+}
+
+func (pl *ProjectLock) RepoNames() []string {
+	names := make([]string, 0, len(pl.repos))
+	for name, _ := range pl.repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (pl *ProjectLock) Replace(lr *LockedRepo) {
+	cur := pl.repos[lr.Name]
+	if cur == nil || *cur != *lr {
+		pl.repos[lr.Name] = lr
+		pl.dirty = true
+	}
+}
+
+func (pl *ProjectLock) Remove(rname string) {
+	if _, ok := pl.repos[rname]; ok {
+		delete(pl.repos, rname)
+		pl.dirty = true
+	}
+}
+
+func (pl *ProjectLock) Dirty() bool {
+	return pl.dirty
+}
+
+func (pl *ProjectLock) Save() error {
+	file, err := os.Create(pl.LockFile())
+	if err != nil {
+		return util.NewNewtError(err.Error())
+	}
+	defer file.Close()
+
+	file.WriteString("### Generated by newt; do not edit.  " +
+		"Run \"newt upgrade\" to update.\n\n")
+	file.WriteString("repositories:\n")
+	for _, name := range pl.RepoNames() {
+		lr := pl.repos[name]
+		file.WriteString("    " + yaml.EscapeString(name) + ":\n")
+		file.WriteString("        vers: " + yaml.EscapeString(lr.Version) +
+			"\n")
+		file.WriteString("        branch: " + yaml.EscapeString(lr.Branch) +
+			"\n")
+		file.WriteString("        commit: " + yaml.EscapeString(lr.Commit) +
+			"\n")
+		file.WriteString("        url: " + yaml.EscapeString(lr.Url) + "\n")
+	}
+
+	pl.dirty = false
+	return nil
+}
+
+func (pl *ProjectLock) Init() error {
+	pl.repos = map[string]*LockedRepo{}
+
+	// The lock file doesn't exist until a repository is installed.
+	path := pl.LockFile()
+	if util.NodeNotExist(path) {
+		return nil
+	}
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return util.NewNewtError(fmt.Sprintf("Error reading %s: %s", path,
+			err.Error()))
+	}
+
+	for name, repoItf := range v.GetStringMap("repositories") {
+		repoVars := cast.ToStringMapString(repoItf)
+		lr := &LockedRepo{
+			Name:    name,
+			Version: repoVars["vers"],
+			Branch:  repoVars["branch"],
+			Commit:  repoVars["commit"],
+			Url:     repoVars["url"],
+		}
+		if lr.Commit == "" {
+			return util.NewNewtError(fmt.Sprintf("Missing commit for "+
+				"repository %s in %s", name, PROJECT_LOCK_FILE))
+		}
+		if _, err := lr.LoadVersion(); err != nil {
+			return err
+		}
+
+		pl.repos[name] = lr
+	}
+
+	return nil
+}
+
+func LoadProjectLock() (*ProjectLock, error) {
+	pl := &ProjectLock{}
+	if err := pl.Init(); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
@@ -57,7 +57,7 @@ func (ps *ProjectState) Save() error {
 	defer file.Close()
 
 	for k, v := range ps.installedRepos {
-		str := fmt.Sprintf("%s,%d.%d.%d\n", k, v.Major(), v.Minor(), v.Revision())
+		str := fmt.Sprintf("%s,%s\n", k, v.String())
 		file.WriteString(str)
 	}
 
@@ -0,0 +1,148 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package project
+
+import (
+	"fmt"
+	"sort"
+
+	"mynewt.apache.org/newt/newt/downloader"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
+)
+
+// The state of an installed repository.
+type RepoStatus struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	InPlace  bool   `json:"in_place"`
+	Required string `json:"required"`
+
+	// Version recorded in project.state; empty if not installed.
+	Installed string `json:"installed"`
+
+	// Highest version satisfying project.yml, according to the downloaded
+	// repository description; empty if unknown.
+	Latest           string `json:"latest"`
+	UpgradeAvailable bool   `json:"upgrade_available"`
+
+	Commit   string `json:"commit"`
+	Modified bool   `json:"modified"`
+}
+
+func (proj *Project) repoStatus(r *repo.Repo) (*RepoStatus, error) {
+	rs := &RepoStatus{
+		Name:     r.Name(),
+		Path:     r.Path(),
+		InPlace:  r.InPlace(),
+		Required: r.VersionRequirementsString(),
+	}
+
+	if !r.InPlace() {
+		installed := proj.projState.GetInstalledVersion(r.Name())
+		if installed != nil {
+			rs.Installed = installed.String()
+		}
+
+		if _, err := r.ReadCachedDesc(); err != nil {
+			return nil, err
+		}
+		if latest := r.LatestVersion(); latest != nil {
+			rs.Latest = latest.String()
+			rs.UpgradeAvailable = installed != nil &&
+				latest.CompareVersions(latest, installed) > 0
+		}
+	}
+
+	// Repositories used in place need not be git checkouts.
+	if util.NodeExist(r.Path() + "/.git") {
+		commit, err := r.HeadCommit()
+		if err != nil {
+			return nil, err
+		}
+		rs.Commit = commit
+
+		rs.Modified, err = downloader.HasLocalChanges(r.Path())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rs, nil
+}
+
+// Reports the state of each of the project's repositories, sorted by name.
+func (proj *Project) RepoStatuses() ([]*RepoStatus, error) {
+	rnames := []string{}
+	for rname, _ := range proj.Repos() {
+		if rname != repo.REPO_NAME_LOCAL {
+			rnames = append(rnames, rname)
+		}
+	}
+	sort.Strings(rnames)
+
+	statuses := []*RepoStatus{}
+	for _, rname := range rnames {
+		rs, err := proj.repoStatus(proj.repos[rname])
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, rs)
+	}
+
+	return statuses, nil
+}
+
+func valueOrUnknown(str string, unknown string) string {
+	if str == "" {
+		return unknown
+	}
+	return str
+}
+
+func (rs *RepoStatus) String() string {
+	str := rs.Name + "\n"
+
+	if rs.InPlace {
+		str += fmt.Sprintf("    path:      %s (used in place)\n", rs.Path)
+	} else {
+		str += fmt.Sprintf("    required:  %s\n",
+			valueOrUnknown(rs.Required, "any"))
+		str += fmt.Sprintf("    installed: %s\n",
+			valueOrUnknown(rs.Installed, "not installed"))
+
+		latest := valueOrUnknown(rs.Latest, "unknown")
+		if rs.UpgradeAvailable {
+			latest += " (upgrade available)"
+		}
+		str += fmt.Sprintf("    latest:    %s\n", latest)
+	}
+
+	if rs.Commit != "" {
+		str += fmt.Sprintf("    commit:    %s\n", rs.Commit)
+		modified := "no"
+		if rs.Modified {
+			modified = "yes"
+		}
+		str += fmt.Sprintf("    modified:  %s\n", modified)
+	}
+
+	return str
+}
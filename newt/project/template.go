@@ -0,0 +1,310 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/downloader"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+// File in the per-user newt directory listing named project templates.
+const TEMPLATE_REGISTRY_FILE_NAME = "templates.yml"
+
+// Branch, tag, or commit of a template repository used when none is
+// specified.
+const TEMPLATE_DEFAULT_COMMIT = "master"
+
+// BSP substituted for {{bsp}} when none is specified.
+const TEMPLATE_DEFAULT_BSP = "@apache-mynewt-core/hw/bsp/native"
+
+// Template variables.  Occurrences of {{<name>}} in a template's text files
+// are replaced with the variable's value.
+const TEMPLATE_VAR_PROJECT_NAME = "project.name"
+const TEMPLATE_VAR_BSP = "bsp"
+
+// A project skeleton: either a directory on disk, or a repository which is
+// downloaded.
+type ProjectTemplate struct {
+	Name       string
+	Path       string
+	Downloader downloader.Downloader
+	Commit     string
+
+	// Default value of the bsp variable.
+	Bsp string
+}
+
+// The skeleton used when no template is specified.
+func DefaultTemplate() *ProjectTemplate {
+	dl := downloader.NewGithubDownloader()
+	dl.User = "apache"
+	dl.Repo = "incubator-mynewt-blinky"
+
+	return &ProjectTemplate{
+		Name:       "apache/incubator-mynewt-blinky",
+		Downloader: dl,
+		Commit:     newtutil.NewtBlinkyTag,
+	}
+}
+
+func (t *ProjectTemplate) String() string {
+	if t.Path != "" {
+		return t.Path
+	}
+	return fmt.Sprintf("%s (%s)", t.Downloader.RepoUrl(), t.Commit)
+}
+
+func loadTemplate(name string,
+	tmplVars map[string]string) (*ProjectTemplate, error) {
+
+	t := &ProjectTemplate{
+		Name:   name,
+		Commit: tmplVars["commit"],
+		Bsp:    tmplVars["bsp"],
+	}
+
+	if tmplVars["path"] != "" {
+		path, err := filepath.Abs(os.ExpandEnv(tmplVars["path"]))
+		if err != nil {
+			return nil, util.NewNewtError(err.Error())
+		}
+		t.Path = path
+		return t, nil
+	}
+
+	dl, err := downloader.LoadDownloader(name, tmplVars)
+	if err != nil {
+		return nil, util.NewNewtError(fmt.Sprintf("Invalid template %s in "+
+			"%s: %s", name, TEMPLATE_REGISTRY_FILE_NAME, err.Error()))
+	}
+	t.Downloader = dl
+	if t.Commit == "" {
+		t.Commit = TEMPLATE_DEFAULT_COMMIT
+	}
+
+	return t, nil
+}
+
+// Reads the named templates in ~/.newt/templates.yml, e.g.:
+//
+// templates:
+//     acme:
+//         type: git
+//         url: https://git.example.com/acme/project-template.git
+//         commit: v1.2
+//         bsp: "@acme/hw/bsp/acme_board"
+//     scratch:
+//         path: ~/templates/scratch
+func LoadTemplateRegistry() (map[string]*ProjectTemplate, error) {
+	templates := map[string]*ProjectTemplate{}
+
+	userDir, err := newtutil.NewtUserDir()
+	if err != nil {
+		return nil, err
+	}
+	if util.NodeNotExist(filepath.Join(userDir,
+		TEMPLATE_REGISTRY_FILE_NAME)) {
+
+		return templates, nil
+	}
+
+	v, err := util.ReadConfig(userDir,
+		strings.TrimSuffix(TEMPLATE_REGISTRY_FILE_NAME, ".yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, tmplItf := range v.GetStringMap("templates") {
+		tmplVars := cast.ToStringMapString(tmplItf)
+		if tmplVars["path"] != "" && strings.HasPrefix(tmplVars["path"],
+			"~/") {
+
+			tmplVars["path"] = filepath.Join(filepath.Dir(userDir),
+				tmplVars["path"][2:])
+		}
+
+		t, err := loadTemplate(name, tmplVars)
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = t
+	}
+
+	return templates, nil
+}
+
+// Returns the names of the templates in the registry, sorted.
+func TemplateNames(templates map[string]*ProjectTemplate) []string {
+	names := make([]string, 0, len(templates))
+	for name, _ := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Looks up a template by name in the registry, or, failing that, treats the
+// specification as a local directory or a git url.  A url may be followed by
+// #<branch, tag, or commit>.
+func FindTemplate(spec string) (*ProjectTemplate, error) {
+	if spec == "" {
+		return DefaultTemplate(), nil
+	}
+
+	templates, err := LoadTemplateRegistry()
+	if err != nil {
+		return nil, err
+	}
+	if t := templates[spec]; t != nil {
+		return t, nil
+	}
+
+	if util.NodeExist(spec) {
+		return loadTemplate(spec, map[string]string{"path": spec})
+	}
+
+	if strings.Contains(spec, "://") || strings.HasPrefix(spec, "git@") ||
+		strings.HasSuffix(spec, ".git") || strings.Contains(spec, ".git#") {
+
+		url := spec
+		commit := ""
+		if i := strings.LastIndex(spec, "#"); i >= 0 {
+			url = spec[:i]
+			commit = spec[i+1:]
+		}
+		return loadTemplate(spec, map[string]string{
+			"type":   "git",
+			"url":    url,
+			"commit": commit,
+		})
+	}
+
+	names := TemplateNames(templates)
+	if len(names) == 0 {
+		return nil, util.NewNewtError(fmt.Sprintf("Unknown template %s; "+
+			"must be a directory, a git url, or a template listed in "+
+			"~/%s/%s", spec, newtutil.NEWT_USER_DIR,
+			TEMPLATE_REGISTRY_FILE_NAME))
+	}
+	return nil, util.NewNewtError(fmt.Sprintf("Unknown template %s; must be "+
+		"a directory, a git url, or one of: %s", spec,
+		strings.Join(names, ", ")))
+}
+
+// Replaces the template variables in each text file under the specified
+// directory.
+func substituteTemplateVars(dir string, vars map[string]string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo,
+		err error) error {
+
+		if err != nil {
+			return util.NewNewtError(err.Error())
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return util.NewNewtError(err.Error())
+		}
+
+		// Leave binary files alone.
+		if bytes.IndexByte(contents, 0) >= 0 {
+			return nil
+		}
+
+		replaced := []byte(newtutil.ExpandTemplateVars(string(contents),
+			vars))
+		if bytes.Equal(replaced, contents) {
+			return nil
+		}
+
+		log.Debugf("Substituting template variables in %s", path)
+		if err := ioutil.WriteFile(path, replaced,
+			info.Mode().Perm()); err != nil {
+
+			return util.NewNewtError(err.Error())
+		}
+
+		return nil
+	})
+}
+
+// Creates a new project in the specified directory from the template.  The
+// template's {{project.name}} variable is set to the directory's name, and
+// {{bsp}} to the specified BSP, or the template's default BSP.
+func (t *ProjectTemplate) CreateProject(newDir string, bsp string) error {
+	srcDir := t.Path
+	if srcDir == "" {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Downloading project "+
+			"skeleton from %s...\n", t.String())
+
+		dir, err := t.Downloader.DownloadRepo(t.Commit)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		srcDir = dir
+	} else if util.NodeNotExist(srcDir) {
+		return util.NewNewtError(fmt.Sprintf("Template directory %s does "+
+			"not exist", srcDir))
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Installing skeleton in "+
+		"%s...\n", newDir)
+
+	if err := util.CopyDir(srcDir, newDir); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(newDir + "/.git/"); err != nil {
+		return util.NewNewtError(err.Error())
+	}
+
+	if bsp == "" {
+		bsp = t.Bsp
+	}
+	if bsp == "" {
+		bsp = TEMPLATE_DEFAULT_BSP
+	}
+
+	vars := map[string]string{
+		TEMPLATE_VAR_PROJECT_NAME: filepath.Base(filepath.Clean(newDir)),
+		TEMPLATE_VAR_BSP:          bsp,
+	}
+	if err := substituteTemplateVars(newDir, vars); err != nil {
+		return err
+	}
+
+	return nil
+}
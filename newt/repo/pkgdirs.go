@@ -0,0 +1,164 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// File in the root of a repository listing paths which are never searched
+// for packages.
+const REPO_IGNORE_FILE_NAME = ".newtignore"
+
+// Package layout of an installed repository, as declared in the
+// repository.yml of the installed version:
+//
+// repo.pkg_dirs: [list of directories to search for packages]
+// repo.pkg_discover: true (search the entire repository)
+type pkgLayout struct {
+	dirs     []string
+	ignore   []string
+	discover bool
+}
+
+func readIgnoreFile(path string) ([]string, error) {
+	if util.NodeNotExist(path) {
+		return nil, nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.NewNewtError(err.Error())
+	}
+
+	patterns := []string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pattern := strings.TrimSuffix(line, "/")
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, util.NewNewtError(fmt.Sprintf("Invalid pattern "+
+				"\"%s\" in %s: %s", line, path, err.Error()))
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
+}
+
+func (r *Repo) loadPkgLayout() error {
+	if r.layout != nil {
+		return nil
+	}
+
+	layout := &pkgLayout{}
+
+	ignore, err := readIgnoreFile(r.Path() + "/" + REPO_IGNORE_FILE_NAME)
+	if err != nil {
+		return err
+	}
+	layout.ignore = ignore
+
+	// The local repository's layout is specified in project.yml.
+	if !r.IsLocal() && util.NodeExist(r.Path()+"/"+REPO_FILE_NAME) {
+		v, err := util.ReadConfig(r.Path(),
+			strings.TrimSuffix(REPO_FILE_NAME, ".yml"))
+		if err != nil {
+			return err
+		}
+
+		layout.discover = v.GetBool("repo.pkg_discover")
+		for _, dir := range v.GetStringSlice("repo.pkg_dirs") {
+			dir = filepath.Clean(dir)
+			if filepath.IsAbs(dir) || strings.HasPrefix(dir, "..") {
+				return util.NewNewtError(fmt.Sprintf("Invalid package "+
+					"directory %s in repository %s; must be relative to "+
+					"the repository root", dir, r.Name()))
+			}
+			layout.dirs = append(layout.dirs, dir)
+		}
+	}
+
+	log.Debugf("Package layout of repository %s: dirs=%v discover=%t "+
+		"ignore=%v", r.Name(), layout.dirs, layout.discover, layout.ignore)
+
+	r.layout = layout
+	return nil
+}
+
+// Returns the directories, relative to the repository root, which contain
+// this repository's packages.  The repository root is returned if the
+// repository asks for its packages to be discovered.  If the repository
+// doesn't declare its layout, the specified default directories are
+// returned.
+func (r *Repo) PackageDirs(defaultDirs []string) ([]string, error) {
+	if err := r.loadPkgLayout(); err != nil {
+		return nil, err
+	}
+
+	if r.layout.discover {
+		return []string{"."}, nil
+	}
+	if len(r.layout.dirs) > 0 {
+		return r.layout.dirs, nil
+	}
+
+	return defaultDirs, nil
+}
+
+// Indicates whether the specified path is excluded from the package search
+// by the repository's ignore file.  Patterns without a slash match any path
+// component; other patterns are matched against the entire path relative to
+// the repository root.
+func (r *Repo) PathIgnored(path string) bool {
+	if err := r.loadPkgLayout(); err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(r.Path(), path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	for _, pattern := range r.layout.ignore {
+		if strings.Contains(pattern, "/") {
+			pattern = strings.TrimPrefix(pattern, "/")
+			if ok, _ := filepath.Match(pattern, rel); ok {
+				return true
+			}
+		} else {
+			if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
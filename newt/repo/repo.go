@@ -23,9 +23,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cast"
 
 	"mynewt.apache.org/newt/newt/downloader"
 	"mynewt.apache.org/newt/newt/interfaces"
@@ -47,6 +49,23 @@ type Repo struct {
 	rdesc      *RepoDesc
 	deps       []*RepoDependency
 	updated    bool
+
+	// Whether this repository is used in place from a path on disk (type:
+	// local), rather than downloaded into the project's repos directory.
+	inPlace bool
+
+	// Dependencies of each branch listed in the repository description.
+	branchDeps map[string][]*RepoDependency
+
+	// Version selected by the dependency solver.
+	resolvedVers *Version
+
+	// Expected SHA-256 hash of the downloaded repository description, if
+	// pinned.
+	descHash string
+
+	// Package directories and ignore patterns; loaded on first use.
+	layout *pkgLayout
 }
 
 type RepoDesc struct {
@@ -85,46 +104,6 @@ func NewRepoDependency(rname string, verstr string) (*RepoDependency, error) {
 	return rd, nil
 }
 
-func CheckDeps(upgrade bool, checkRepos map[string]*Repo) error {
-	// For each dependency, get it's version
-	depArray := map[string][]*Version{}
-
-	for _, checkRepo := range checkRepos {
-		for _, rd := range checkRepo.Deps() {
-			lookupRepo := checkRepos[rd.Name()]
-
-			_, vers, ok := lookupRepo.rdesc.Match(rd.Storerepo)
-			if !ok {
-				return util.NewNewtError(fmt.Sprintf("No "+
-					"matching version for dependent repository %s", rd.name))
-			}
-			log.Debugf("Dependency for %s: %s (%s)", checkRepo.Name(), rd.Name(), vers.String())
-
-			_, ok = depArray[rd.Name()]
-			if !ok {
-				depArray[rd.Name()] = []*Version{}
-			}
-			depArray[rd.Name()] = append(depArray[rd.Name()], vers)
-		}
-	}
-
-	for repoName, depVersList := range depArray {
-		for _, depVers := range depVersList {
-			for _, curVers := range depVersList {
-				if depVers.CompareVersions(depVers, curVers) != 0 ||
-					depVers.Stability() != curVers.Stability() {
-					return util.NewNewtError(fmt.Sprintf(
-						"Conflict detected.  Repository %s has multiple dependency versions on %s. "+
-							"Notion of repository version is %s, whereas required is %s ",
-						repoName, curVers, depVers))
-				}
-			}
-		}
-	}
-
-	return nil
-}
-
 func (rd *RepoDesc) MatchVersion(searchVers *Version) (string, *Version, bool) {
 	for vers, curBranch := range rd.vers {
 		if vers.CompareVersions(vers, searchVers) == 0 &&
@@ -136,7 +115,13 @@ func (rd *RepoDesc) MatchVersion(searchVers *Version) (string, *Version, bool) {
 }
 
 func (rd *RepoDesc) Match(r *Repo) (string, *Version, bool) {
-	for vers, branch := range rd.vers {
+	if r.resolvedVers != nil {
+		return rd.MatchVersion(r.resolvedVers)
+	}
+
+	// Consider the highest versions first.
+	for _, vers := range rd.sortedAllVersions() {
+		branch := rd.vers[vers]
 		log.Debugf("Repository version requires for %s are %s\n", r.Name(), r.VersionRequirements())
 		if vers.SatisfiesVersion(r.VersionRequirements()) {
 			log.Debugf("Found matching version %s for repo %s",
@@ -256,61 +241,188 @@ func (r *Repo) IsLocal() bool {
 	return r.name == REPO_NAME_LOCAL
 }
 
+func (r *Repo) InPlace() bool {
+	return r.inPlace
+}
+
+func (r *Repo) ResolvedVersion() *Version {
+	return r.resolvedVers
+}
+
 func (r *Repo) VersionRequirements() []interfaces.VersionReqInterface {
 	return r.versreq
 }
 
 func (r *Repo) VersionRequirementsString() string {
-	str := ""
+	strs := []string{}
 	for _, vreq := range r.versreq {
-		str += vreq.String()
+		strs = append(strs, vreq.String())
 	}
 
-	return str
+	return strings.Join(strs, ",")
 }
 
-func (r *Repo) repoFilePath() string {
-	return interfaces.GetProject().Path() + "/" + REPOS_DIR + "/" +
-		".configs/" + r.name + "/"
+// Reads the repository description downloaded by a previous install or
+// upgrade, if there is one.
+func (r *Repo) ReadCachedDesc() (*RepoDesc, error) {
+	if r.rdesc != nil {
+		return r.rdesc, nil
+	}
+
+	if util.NodeNotExist(r.repoFilePath() + REPO_FILE_NAME) {
+		return nil, nil
+	}
+
+	rdesc, _, err := r.ReadDesc()
+	return rdesc, err
 }
 
-func (r *Repo) Install(force bool) (*Version, error) {
-	// Copy the git repo into /repos/, error'ing out if the repo already exists
-	if util.NodeExist(r.Path()) {
-		if force {
-			if err := os.RemoveAll(r.Path()); err != nil {
-				return nil, util.NewNewtError(err.Error())
-			}
-		} else {
-			return nil, util.NewNewtError(fmt.Sprintf("Repository %s already "+
-				"exists in local tree, cannot install.  Provide -f to override.", r.Path()))
+// Returns the highest version in the repository description which satisfies
+// this repository's version requirements.
+func (r *Repo) LatestVersion() *Version {
+	if r.rdesc == nil {
+		return nil
+	}
+
+	for _, vers := range r.rdesc.SortedVersions() {
+		if r.rdesc.SatisfiesVersion(vers, r.VersionRequirements()) {
+			return vers
 		}
 	}
 
+	return nil
+}
+
+// Returns the directory containing the repository description.  A repository
+// used in place is described by the repository.yml in its own directory.
+func (r *Repo) repoFilePath() string {
+	if r.inPlace {
+		return r.localPath + "/"
+	}
+	return interfaces.GetProject().Path() + "/" + REPOS_DIR + "/" +
+		".configs/" + r.name + "/"
+}
+
+func (r *Repo) Install(upgrade bool, force bool) (*Version, error) {
 	branchName, vers, found := r.rdesc.Match(r)
 	if !found {
 		return nil, util.NewNewtError(fmt.Sprintf("No repository matching description %s found",
 			r.rdesc.String()))
 	}
 
+	if err := r.install(upgrade, force, branchName); err != nil {
+		return nil, err
+	}
+
+	return vers, nil
+}
+
+// Installs the repository checked out at the specified commit, e.g., a commit
+// recorded in the project lock file.
+func (r *Repo) InstallCommit(upgrade bool, force bool, commit string) error {
+	return r.install(upgrade, force, commit)
+}
+
+func (r *Repo) isCheckout() bool {
+	return util.NodeExist(r.Path() + "/.git")
+}
+
+// Installs the specified branch, tag, or commit of the repository into
+// /repos/.  An existing git checkout is updated in place when upgrading or
+// forced; local modifications prevent the update unless forced, in which
+// case they are discarded.
+func (r *Repo) install(upgrade bool, force bool, commit string) error {
+	if util.NodeExist(r.Path()) {
+		if !upgrade && !force {
+			return util.NewNewtError(fmt.Sprintf("Repository %s already "+
+				"exists in local tree, cannot install.  Provide -f to override.", r.Path()))
+		}
+
+		if r.isCheckout() {
+			return r.update(force, commit)
+		}
+
+		// Not a git checkout; replace it.
+		if err := os.RemoveAll(r.Path()); err != nil {
+			return util.NewNewtError(err.Error())
+		}
+	}
+
 	dl := r.downloader
 
 	// Download the git repo, returns the git repo, checked out to that branch
-	tmpdir, err := dl.DownloadRepo(branchName)
+	tmpdir, err := dl.DownloadRepo(commit)
 	if err != nil {
-		return nil, util.NewNewtError(fmt.Sprintf("Error download repository %s, : %s",
+		return util.NewNewtError(fmt.Sprintf("Error download repository %s, : %s",
 			r.Name(), err.Error()))
 	}
 
-	// Copy the Git repo into the the desired local path of the repo
-	if err := util.CopyDir(tmpdir, r.Path()); err != nil {
-		// Cleanup any directory that might have been created if we error out
-		// here.
-		os.RemoveAll(r.Path())
-		return nil, err
+	// Move the Git repo into the the desired local path of the repo.  Fall
+	// back to copying if the temporary directory is on another file system.
+	if err := os.MkdirAll(filepath.Dir(r.Path()),
+		REPO_DEFAULT_PERMS); err != nil {
+
+		return util.NewNewtError(err.Error())
+	}
+	if err := os.Rename(tmpdir, r.Path()); err != nil {
+		err := util.CopyDir(tmpdir, r.Path())
+		os.RemoveAll(tmpdir)
+		if err != nil {
+			// Cleanup any directory that might have been created if we
+			// error out here.
+			os.RemoveAll(r.Path())
+			return err
+		}
+	}
+	if err := os.Chmod(r.Path(), REPO_DEFAULT_PERMS); err != nil {
+		return util.NewNewtError(err.Error())
 	}
 
-	return vers, nil
+	return nil
+}
+
+// Fetches into the installed checkout and checks out the specified commit.
+func (r *Repo) update(force bool, commit string) error {
+	changed, err := downloader.HasLocalChanges(r.Path())
+	if err != nil {
+		return err
+	}
+	if changed && !force {
+		return util.NewNewtError(fmt.Sprintf("Repository %s has local "+
+			"modifications; commit or stash them, or provide -f to "+
+			"discard them.", r.Path()))
+	}
+
+	return r.downloader.UpdateRepo(r.Path(), commit, force)
+}
+
+// Returns the branch that the specified version of this repository maps to
+// in its repository description.
+func (r *Repo) VersionBranch(vers *Version) string {
+	if r.rdesc == nil || vers == nil {
+		return ""
+	}
+
+	branch, _, _ := r.rdesc.MatchVersion(vers)
+	return branch
+}
+
+// Returns the URL the repository is downloaded from.
+func (r *Repo) Url() string {
+	if r.downloader == nil {
+		return ""
+	}
+	return r.downloader.RepoUrl()
+}
+
+// Creates or updates the mirror of this repository in the repo cache.
+func (r *Repo) Mirror() error {
+	return downloader.MirrorRepo(r.Url())
+}
+
+// Returns the hash of the commit currently installed in the repos directory.
+func (r *Repo) HeadCommit() (string, error) {
+	return downloader.HeadCommit(r.Path())
 }
 
 func (r *Repo) UpdateDesc() ([]*Repo, bool, error) {
@@ -336,6 +448,37 @@ func (r *Repo) UpdateDesc() ([]*Repo, bool, error) {
 	return repos, true, nil
 }
 
+// Pins the repository description to the specified SHA-256 hash.  A
+// downloaded description which doesn't match is rejected.
+func (r *Repo) SetDescHash(hash string) {
+	r.descHash = strings.ToLower(strings.TrimSpace(hash))
+}
+
+// Returns the SHA-256 hash of the downloaded repository description.
+func (r *Repo) DescHash() (string, error) {
+	return downloader.FileHash(r.repoFilePath() + REPO_FILE_NAME)
+}
+
+func (r *Repo) verifyDesc() error {
+	if r.descHash == "" {
+		return nil
+	}
+
+	hash, err := r.DescHash()
+	if err != nil {
+		return err
+	}
+
+	if hash != r.descHash {
+		return util.NewNewtError(fmt.Sprintf("Description of repository %s "+
+			"(%s) does not match pinned hash %s (actual hash: %s); the "+
+			"repository or its mirror may have been tampered with", r.Name(),
+			r.Url(), r.descHash, hash))
+	}
+
+	return nil
+}
+
 // Download the repository description.
 func (r *Repo) DownloadDesc() error {
 	dl := r.downloader
@@ -358,41 +501,46 @@ func (r *Repo) DownloadDesc() error {
 		return err
 	}
 
+	if err := r.verifyDesc(); err != nil {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, " failed\n")
+		os.Remove(cpath + "/" + "repository.yml")
+		return err
+	}
+
 	util.StatusMessage(util.VERBOSITY_VERBOSE, " success!\n")
 
 	return nil
 }
 
-func (r *Repo) readDepRepos(v *viper.Viper) ([]*Repo, error) {
-	rdesc := r.rdesc
-	repos := []*Repo{}
-
-	branch, _, ok := rdesc.Match(r)
-	if !ok {
-		// No matching branch, barf!
-		return nil, util.NewNewtError(fmt.Sprintf("No "+
-			"matching branch for %s repo", r.Name()))
-	}
+// Reads the repositories that the specified branch of this repository
+// depends on.
+func readBranchDeps(v *viper.Viper, branch string) ([]*RepoDependency,
+	error) {
 
 	repoTag := fmt.Sprintf("%s.repositories", branch)
 
 	repoList := v.GetStringMap(repoTag)
-	for repoName, repoItf := range repoList {
-		repoVars := repoItf.(map[interface{}]interface{})
+	repoNames := make([]string, 0, len(repoList))
+	for repoName, _ := range repoList {
+		repoNames = append(repoNames, repoName)
+	}
+	sort.Strings(repoNames)
 
-		if repoVars["type"] != "github" {
-			return nil, util.NewNewtError("Only github repositories are currently supported.")
-		}
+	deps := []*RepoDependency{}
+	for _, repoName := range repoNames {
+		repoVars := cast.ToStringMapString(repoList[repoName])
 
-		rversreq := repoVars["vers"].(string)
-		dl := downloader.NewGithubDownloader()
-		dl.User = repoVars["user"].(string)
-		dl.Repo = repoVars["repo"].(string)
+		rversreq := repoVars["vers"]
+		dl, err := downloader.LoadDownloader(repoName, repoVars)
+		if err != nil {
+			return nil, err
+		}
 
 		newRepo, err := NewRepo(repoName, rversreq, dl)
 		if err != nil {
 			return nil, err
 		}
+		newRepo.SetDescHash(repoVars["desc_sha256"])
 
 		rd, err := NewRepoDependency(repoName, rversreq)
 		if err != nil {
@@ -400,14 +548,57 @@ func (r *Repo) readDepRepos(v *viper.Viper) ([]*Repo, error) {
 		}
 		rd.Storerepo = newRepo
 
-		r.AddDependency(rd)
+		deps = append(deps, rd)
+	}
+
+	return deps, nil
+}
+
+func (r *Repo) readDepRepos(v *viper.Viper) ([]*Repo, error) {
+	rdesc := r.rdesc
+	repos := []*Repo{}
+
+	// Record the dependencies of every version, so that the dependency
+	// solver can consider each of them.
+	r.branchDeps = map[string][]*RepoDependency{}
+	for _, vers := range rdesc.SortedVersions() {
+		branch, _, _ := rdesc.MatchVersion(vers)
+		deps, err := readBranchDeps(v, branch)
+		if err != nil {
+			return nil, err
+		}
+		r.branchDeps[branch] = deps
+	}
 
-		repos = append(repos, newRepo)
+	// If no version matches, the dependency solver explains why.
+	branch, _, ok := rdesc.Match(r)
+	if !ok {
+		return repos, nil
+	}
+
+	for _, rd := range r.branchDeps[branch] {
+		r.AddDependency(rd)
+		repos = append(repos, rd.Storerepo)
 	}
 
 	return repos, nil
 }
 
+// Returns the repositories that the specified version of this repository
+// depends on.
+func (r *Repo) VersionDeps(vers *Version) []*RepoDependency {
+	if r.rdesc == nil {
+		return nil
+	}
+
+	branch, _, ok := r.rdesc.MatchVersion(vers)
+	if !ok {
+		return nil
+	}
+
+	return r.branchDeps[branch]
+}
+
 func (r *Repo) ReadDesc() (*RepoDesc, []*Repo, error) {
 	if util.NodeNotExist(r.repoFilePath() + REPO_FILE_NAME) {
 		return nil, nil,
@@ -478,3 +669,36 @@ func NewLocalRepo() (*Repo, error) {
 
 	return r, nil
 }
+
+// Creates a repository that is used in place from the specified directory.
+// Relative paths are relative to the project base directory.  The repository
+// is never downloaded or installed, and no version matching is done for it.
+// If the directory contains a repository.yml, the repositories which its
+// highest version depends on become dependencies of the project.
+func NewInPlaceRepo(repoName string, path string) (*Repo, error) {
+	r := &Repo{}
+
+	if err := r.Init(repoName, "", nil); err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(interfaces.GetProject().Path(), path)
+	}
+	r.localPath = filepath.Clean(path)
+	r.inPlace = true
+
+	if util.NodeNotExist(r.localPath) {
+		return nil, util.NewNewtError(fmt.Sprintf("Path for local "+
+			"repository %s does not exist: %s", repoName, r.localPath))
+	}
+
+	if util.NodeExist(r.repoFilePath() + REPO_FILE_NAME) {
+		if _, _, err := r.ReadDesc(); err != nil {
+			return nil, err
+		}
+	}
+	r.updated = true
+
+	return r, nil
+}
@@ -0,0 +1,353 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Name given to the project in requirement chains.
+const SOLVER_ROOT_NAME = "project.yml"
+
+type VersionArray []*Version
+
+func (array VersionArray) Len() int {
+	return len(array)
+}
+
+// Highest version first; for equal versions, no stability sorts first.
+func (array VersionArray) Less(i, j int) bool {
+	r := array[i].CompareVersions(array[i], array[j])
+	if r != 0 {
+		return r > 0
+	}
+	return stabilityRank(array[i].Stability()) <
+		stabilityRank(array[j].Stability())
+}
+
+func (array VersionArray) Swap(i, j int) {
+	array[i], array[j] = array[j], array[i]
+}
+
+func stabilityRank(stability string) int {
+	switch stability {
+	case VERSION_STABILITY_NONE:
+		return 0
+	case VERSION_STABILITY_STABLE:
+		return 1
+	case VERSION_STABILITY_LATEST:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Returns every version in the description, highest first.
+func (rd *RepoDesc) sortedAllVersions() []*Version {
+	versions := VersionArray{}
+	for vers, _ := range rd.vers {
+		versions = append(versions, vers)
+	}
+	sort.Sort(versions)
+	return versions
+}
+
+// Returns the concrete (i.e., not stability alias) versions in the
+// description, highest first.
+func (rd *RepoDesc) SortedVersions() []*Version {
+	versions := []*Version{}
+	for _, vers := range rd.sortedAllVersions() {
+		if vers.Stability() == VERSION_STABILITY_NONE {
+			versions = append(versions, vers)
+		}
+	}
+	return versions
+}
+
+// A version requirement on a repository, along with the chain of
+// repositories which led to it.
+type solverReq struct {
+	dep   *RepoDependency
+	chain []string
+}
+
+func (req *solverReq) String() string {
+	versStr := ""
+	for _, vreq := range req.dep.versreq {
+		versStr += vreq.String()
+	}
+	if versStr == "" {
+		versStr = "any version"
+	}
+
+	return fmt.Sprintf("%s requires %s %s", strings.Join(req.chain, " -> "),
+		req.dep.Name(), versStr)
+}
+
+// Loads the description of a newly discovered repository.  If the project
+// already knows of a repository with the same name, that one is returned
+// instead.
+type RepoLoader func(r *Repo) (*Repo, error)
+
+type RepoSolver struct {
+	repos    map[string]*Repo
+	load     RepoLoader
+	assigned map[string]*Version
+	chains   map[string][]string
+	reqs     map[string][]*solverReq
+
+	// Explanation of the first conflict encountered.
+	conflict string
+}
+
+// Creates a solver for the specified repositories.  Repositories that are
+// discovered while solving are loaded with the specified loader.
+func NewRepoSolver(repos map[string]*Repo, load RepoLoader) *RepoSolver {
+	return &RepoSolver{
+		repos:    repos,
+		load:     load,
+		assigned: map[string]*Version{},
+		chains:   map[string][]string{},
+		reqs:     map[string][]*solverReq{},
+	}
+}
+
+// Looks up a repository, loading it if it has not been seen yet.
+func (s *RepoSolver) repo(dep *RepoDependency) (*Repo, error) {
+	if r := s.repos[dep.Name()]; r != nil {
+		return r, nil
+	}
+
+	if dep.Storerepo == nil || s.load == nil {
+		return nil, util.NewNewtError(fmt.Sprintf("Unknown repository %s",
+			dep.Name()))
+	}
+
+	r, err := s.load(dep.Storerepo)
+	if err != nil {
+		return nil, err
+	}
+	s.repos[r.Name()] = r
+
+	return r, nil
+}
+
+// Indicates whether versions are selected for the specified repository.
+// Repositories used in place have no versions.
+func (s *RepoSolver) versioned(name string) bool {
+	r := s.repos[name]
+	return r == nil || (!r.IsLocal() && !r.InPlace())
+}
+
+func (s *RepoSolver) satisfies(r *Repo, vers *Version,
+	reqs []*solverReq) *solverReq {
+
+	for _, req := range reqs {
+		if !r.rdesc.SatisfiesVersion(vers, req.dep.versreq) {
+			return req
+		}
+	}
+	return nil
+}
+
+func (s *RepoSolver) setConflict(msg string) {
+	if s.conflict == "" {
+		s.conflict = msg
+	}
+}
+
+func (s *RepoSolver) explain(name string, reqs []*solverReq) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("No version of repository %s satisfies "+
+		"all requirements:\n", name))
+	for _, req := range reqs {
+		buffer.WriteString("    " + req.String() + "\n")
+	}
+	return buffer.String()
+}
+
+// Returns the next repository to select a version for: the first one, by
+// name, which is required but not yet selected.
+func (s *RepoSolver) next() string {
+	names := []string{}
+	for name, reqs := range s.reqs {
+		if len(reqs) > 0 && s.assigned[name] == nil && s.versioned(name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+
+	sort.Strings(names)
+	return names[0]
+}
+
+func (s *RepoSolver) addReqs(chain []string, deps []*RepoDependency) {
+	for _, dep := range deps {
+		s.reqs[dep.Name()] = append(s.reqs[dep.Name()], &solverReq{
+			dep:   dep,
+			chain: chain,
+		})
+	}
+}
+
+func (s *RepoSolver) removeReqs(deps []*RepoDependency) {
+	for i := len(deps) - 1; i >= 0; i-- {
+		name := deps[i].Name()
+		s.reqs[name] = s.reqs[name][:len(s.reqs[name])-1]
+	}
+}
+
+// Selects a version for each required repository, trying the highest
+// satisfying versions first and backtracking on conflicts.
+func (s *RepoSolver) solve() (bool, error) {
+	name := s.next()
+	if name == "" {
+		return true, nil
+	}
+
+	reqs := s.reqs[name]
+	r, err := s.repo(reqs[0].dep)
+	if err != nil {
+		return false, err
+	}
+	if r.IsLocal() || r.InPlace() {
+		return s.solve()
+	}
+	if r.rdesc == nil {
+		return false, util.NewNewtError(fmt.Sprintf("Repository "+
+			"description for %s not yet initialized", name))
+	}
+
+	// The chain of the first requirement explains why the repository is
+	// needed.
+	chain := append(append([]string{}, reqs[0].chain...), name)
+
+	found := false
+	for _, vers := range r.rdesc.SortedVersions() {
+		if s.satisfies(r, vers, reqs) != nil {
+			continue
+		}
+		found = true
+
+		// Make sure the dependencies of this version agree with the versions
+		// already selected.
+		deps := r.VersionDeps(vers)
+		depChain := append(append([]string{}, chain[:len(chain)-1]...),
+			fmt.Sprintf("%s (%s)", name, vers))
+
+		ok := true
+		for _, dep := range deps {
+			depVers := s.assigned[dep.Name()]
+			if depVers == nil {
+				continue
+			}
+			depRepo := s.repos[dep.Name()]
+			req := &solverReq{dep: dep, chain: depChain}
+			if s.satisfies(depRepo, depVers, []*solverReq{req}) != nil {
+				s.setConflict(s.explain(dep.Name(),
+					append(append([]*solverReq{}, s.reqs[dep.Name()]...),
+						req)))
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		log.Debugf("Trying version %s of repository %s", vers, name)
+
+		s.assigned[name] = vers
+		s.chains[name] = chain
+		s.addReqs(depChain, deps)
+
+		solved, err := s.solve()
+		if err != nil {
+			return false, err
+		}
+		if solved {
+			return true, nil
+		}
+
+		s.removeReqs(deps)
+		delete(s.chains, name)
+		delete(s.assigned, name)
+	}
+
+	if !found {
+		s.setConflict(s.explain(name, reqs))
+	}
+
+	return false, nil
+}
+
+// Selects the highest version of each repository that satisfies all the
+// requirements in project.yml and in the descriptions of the selected
+// versions of every dependent repository.  On success, each selected
+// repository is marked with its version, and the selected repositories are
+// returned.
+func (s *RepoSolver) Solve(rootDeps []*RepoDependency) (map[string]*Repo,
+	error) {
+
+	s.addReqs([]string{SOLVER_ROOT_NAME}, rootDeps)
+
+	// Repositories used in place are always part of the project; the
+	// repositories they depend on are required as well.
+	names := []string{}
+	for name, r := range s.repos {
+		if r.InPlace() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s.addReqs([]string{SOLVER_ROOT_NAME, name + " (local)"},
+			s.repos[name].Deps())
+	}
+
+	solved, err := s.solve()
+	if err != nil {
+		return nil, err
+	}
+	if !solved {
+		return nil, util.NewNewtError(s.conflict)
+	}
+
+	selected := map[string]*Repo{}
+	for name, vers := range s.assigned {
+		r := s.repos[name]
+		r.resolvedVers = vers
+		selected[name] = r
+
+		log.Debugf("Selected version %s of repository %s (%s)", vers, name,
+			strings.Join(s.chains[name], " -> "))
+	}
+
+	return selected, nil
+}
@@ -29,8 +29,6 @@ import (
 	"mynewt.apache.org/newt/util"
 )
 
-const VERSION_FORMAT = "%d.%d.%d-%s"
-
 const (
 	VERSION_STABILITY_NONE   = "none"
 	VERSION_STABILITY_STABLE = "stable"
@@ -48,6 +46,9 @@ type Version struct {
 	minor     int64
 	revision  int64
 	stability string
+
+	// Semver prerelease tag (e.g., rc1 in 1.0.0-rc1).
+	prerelease string
 }
 
 func (vm *VersionMatch) CompareType() string {
@@ -78,6 +79,50 @@ func (v *Version) Stability() string {
 	return v.stability
 }
 
+func (v *Version) Prerelease() string {
+	return v.prerelease
+}
+
+// Compares two prerelease tags according to semver precedence: identifiers
+// are compared one at a time, numeric identifiers numerically and lower than
+// alphanumeric ones; a version without a prerelease tag is higher than one
+// with a tag.
+func comparePrereleases(pre1 string, pre2 string) int64 {
+	if pre1 == pre2 {
+		return 0
+	}
+	if pre1 == "" {
+		return 1
+	}
+	if pre2 == "" {
+		return -1
+	}
+
+	ids1 := strings.Split(pre1, ".")
+	ids2 := strings.Split(pre2, ".")
+	for i := 0; i < len(ids1) && i < len(ids2); i++ {
+		n1, err1 := strconv.ParseInt(ids1[i], 10, 64)
+		n2, err2 := strconv.ParseInt(ids2[i], 10, 64)
+
+		switch {
+		case err1 == nil && err2 == nil:
+			if n1 != n2 {
+				return n1 - n2
+			}
+		case err1 == nil:
+			return -1
+		case err2 == nil:
+			return 1
+		default:
+			if r := strings.Compare(ids1[i], ids2[i]); r != 0 {
+				return int64(r)
+			}
+		}
+	}
+
+	return int64(len(ids1) - len(ids2))
+}
+
 func (v *Version) CompareVersions(vers1 interfaces.VersionInterface,
 	vers2 interfaces.VersionInterface) int64 {
 	if r := vers1.Major() - vers2.Major(); r != 0 {
@@ -92,10 +137,37 @@ func (v *Version) CompareVersions(vers1 interfaces.VersionInterface,
 		return r
 	}
 
-	return 0
+	return comparePrereleases(vers1.Prerelease(), vers2.Prerelease())
+}
+
+// Indicates whether a prerelease version is allowed by the specified
+// requirements.  As with semver ranges, a prerelease only satisfies
+// requirements which explicitly mention a prerelease of the same
+// major.minor.revision.
+func (v *Version) prereleaseAllowed(
+	versMatches []interfaces.VersionReqInterface) bool {
+
+	if v.Prerelease() == "" {
+		return true
+	}
+
+	for _, match := range versMatches {
+		mv := match.Version()
+		if mv.Prerelease() != "" && mv.Major() == v.Major() &&
+			mv.Minor() == v.Minor() && mv.Revision() == v.Revision() {
+
+			return true
+		}
+	}
+
+	return false
 }
 
 func (v *Version) SatisfiesVersion(versMatches []interfaces.VersionReqInterface) bool {
+	if !v.prereleaseAllowed(versMatches) {
+		return false
+	}
+
 	if versMatches == nil {
 		return true
 	}
@@ -125,6 +197,7 @@ func (v *Version) SatisfiesVersion(versMatches []interfaces.VersionReqInterface)
 			}
 		}
 
+		// Stability aliases (e.g., 1-latest) only match each other.
 		if match.Version().Stability() != v.Stability() {
 			return false
 		}
@@ -134,57 +207,87 @@ func (v *Version) SatisfiesVersion(versMatches []interfaces.VersionReqInterface)
 }
 
 func (vers *Version) String() string {
-	return fmt.Sprintf(VERSION_FORMAT, vers.Major(), vers.Minor(), vers.Revision(), vers.Stability())
+	str := fmt.Sprintf("%d.%d.%d", vers.Major(), vers.Minor(),
+		vers.Revision())
+	if vers.Prerelease() != "" {
+		str += "-" + vers.Prerelease()
+	}
+	if vers.Stability() != VERSION_STABILITY_NONE {
+		str += "-" + vers.Stability()
+	}
+	return str
 }
 
+var prereleaseRe = regexp.MustCompile(`^[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*$`)
+
+// Parses a version of the form major[.minor[.revision]][-suffix][+build].
+// The suffix is either a stability (stable, dev, or latest), which makes the
+// version an alias for another version in a repository description, or a
+// semver prerelease tag.  Build metadata is ignored.
 func LoadVersion(versStr string) (*Version, error) {
+	vers, _, err := loadVersionParts(versStr)
+	return vers, err
+}
+
+// Parses a version, and also returns the number of numeric components which
+// were specified.
+func loadVersionParts(versStr string) (*Version, int, error) {
 	var err error
 
-	// Split to get stability level first
-	sparts := strings.Split(versStr, "-")
+	versStr = strings.TrimSpace(versStr)
+	if i := strings.Index(versStr, "+"); i >= 0 {
+		versStr = versStr[:i]
+	}
+
+	// Split to get stability level or prerelease first
+	sparts := strings.SplitN(versStr, "-", 2)
 	stability := VERSION_STABILITY_NONE
+	prerelease := ""
 	if len(sparts) > 1 {
-		stability = strings.Trim(sparts[1], " ")
-		switch stability {
-		case VERSION_STABILITY_STABLE:
-			fallthrough
-		case VERSION_STABILITY_DEV:
-			fallthrough
-		case VERSION_STABILITY_LATEST:
+		suffix := strings.Trim(sparts[1], " ")
+		switch suffix {
+		case VERSION_STABILITY_STABLE, VERSION_STABILITY_DEV,
+			VERSION_STABILITY_LATEST:
+
+			stability = suffix
 		default:
-			return nil, util.NewNewtError(
-				fmt.Sprintf("Unknown stability (%s) in version ", stability) + versStr)
+			if !prereleaseRe.MatchString(suffix) {
+				return nil, 0, util.NewNewtError(
+					fmt.Sprintf("Unknown stability (%s) in version ", suffix) + versStr)
+			}
+			prerelease = suffix
 		}
 	}
 
 	parts := strings.Split(sparts[0], ".")
 	if len(parts) > 3 {
-		return nil, util.NewNewtError(fmt.Sprintf("Invalid version string: %s", versStr))
+		return nil, 0, util.NewNewtError(fmt.Sprintf("Invalid version string: %s", versStr))
 	}
 
 	if strings.Trim(parts[0], " ") == "" || strings.Trim(parts[0], " ") == "none" {
-		return nil, nil
+		return nil, 0, nil
 	}
 
 	vers := &Version{}
 	vers.stability = stability
+	vers.prerelease = prerelease
 
 	// convert first string to an int
 	if vers.major, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
-		return nil, util.NewNewtError(err.Error())
+		return nil, 0, util.NewNewtError(err.Error())
 	}
 	if len(parts) >= 2 {
 		if vers.minor, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
-			return nil, util.NewNewtError(err.Error())
+			return nil, 0, util.NewNewtError(err.Error())
 		}
 	}
 	if len(parts) == 3 {
 		if vers.revision, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
-			return nil, util.NewNewtError(err.Error())
+			return nil, 0, util.NewNewtError(err.Error())
 		}
 	}
 
-	return vers, nil
+	return vers, len(parts), nil
 }
 
 func NewVersion(major int64, minor int64, rev int64) *Version {
@@ -193,51 +296,159 @@ func NewVersion(major int64, minor int64, rev int64) *Version {
 	vers.major = major
 	vers.minor = minor
 	vers.revision = rev
+	vers.stability = VERSION_STABILITY_NONE
 
 	return vers
 }
 
+func newVersionMatch(compareType string, vers *Version) *VersionMatch {
+	return &VersionMatch{
+		compareType: compareType,
+		Vers:        vers,
+	}
+}
+
+func isWildcard(part string) bool {
+	return part == "x" || part == "X" || part == "*"
+}
+
+// Expands a wildcard version (e.g., 1.x or 1.2.*) into a range.
+func loadWildcardMatches(versStr string) ([]*VersionMatch, error) {
+	parts := strings.Split(versStr, ".")
+
+	nums := []int64{}
+	for i, part := range parts {
+		if isWildcard(part) {
+			// Everything after a wildcard must also be a wildcard.
+			for _, rest := range parts[i:] {
+				if !isWildcard(rest) {
+					return nil, util.NewNewtError(fmt.Sprintf("Invalid "+
+						"version requirement: %s", versStr))
+				}
+			}
+			break
+		}
+
+		num, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, util.NewNewtError(fmt.Sprintf("Invalid version "+
+				"requirement: %s", versStr))
+		}
+		nums = append(nums, num)
+	}
+
+	switch len(nums) {
+	case 0:
+		return nil, nil
+	case 1:
+		return []*VersionMatch{
+			newVersionMatch(">=", NewVersion(nums[0], 0, 0)),
+			newVersionMatch("<", NewVersion(nums[0]+1, 0, 0)),
+		}, nil
+	case 2:
+		return []*VersionMatch{
+			newVersionMatch(">=", NewVersion(nums[0], nums[1], 0)),
+			newVersionMatch("<", NewVersion(nums[0], nums[1]+1, 0)),
+		}, nil
+	default:
+		return []*VersionMatch{
+			newVersionMatch("==", NewVersion(nums[0], nums[1], nums[2])),
+		}, nil
+	}
+}
+
+// Converts a single constraint into one or more version matches.
+func loadConstraintMatches(op string, versStr string) ([]*VersionMatch,
+	error) {
+
+	numStr := strings.SplitN(strings.SplitN(versStr, "-", 2)[0], "+", 2)[0]
+	for _, part := range strings.Split(numStr, ".") {
+		if isWildcard(part) {
+			if op != "" && op != "=" && op != "==" {
+				return nil, util.NewNewtError(fmt.Sprintf("Invalid "+
+					"version requirement: %s%s", op, versStr))
+			}
+			return loadWildcardMatches(numStr)
+		}
+	}
+
+	vers, numParts, err := loadVersionParts(versStr)
+	if err != nil {
+		return nil, err
+	}
+	if vers == nil {
+		return nil, nil
+	}
+
+	switch op {
+	case "", "=":
+		op = "=="
+
+	case "^", "~":
+		if vers.Stability() != VERSION_STABILITY_NONE {
+			return nil, util.NewNewtError(fmt.Sprintf("Invalid version "+
+				"requirement: %s%s; stability versions can only be "+
+				"matched exactly", op, versStr))
+		}
+
+		var upper *Version
+		switch {
+		case numParts == 1 || (op == "^" && vers.Major() != 0):
+			upper = NewVersion(vers.Major()+1, 0, 0)
+		case op == "~" || vers.Minor() != 0 || numParts == 2:
+			upper = NewVersion(vers.Major(), vers.Minor()+1, 0)
+		default:
+			upper = NewVersion(vers.Major(), vers.Minor(),
+				vers.Revision()+1)
+		}
+
+		return []*VersionMatch{
+			newVersionMatch(">=", vers),
+			newVersionMatch("<", upper),
+		}, nil
+	}
+
+	return []*VersionMatch{newVersionMatch(op, vers)}, nil
+}
+
+var versionConstraintRe = regexp.MustCompile(
+	`^\s*(\^|~|<=|>=|==|=|>|<)?\s*([^\s,<>=^~]+)`)
+
 // Parse a set of version string constraints on a dependency.
-// This function
-// The version string contains a list of version constraints in the following format:
+// The version string contains a comma or space separated list of version
+// constraints, all of which must be satisfied, in the following formats:
 //    - <comparison><version>
+//    - ^<version>: compatible versions; changes which do not modify the
+//      leftmost nonzero component (^1.2 is >=1.2.0 <2.0.0)
+//    - ~<version>: revision changes only, if a minor version is specified
+//      (~1.2.3 is >=1.2.3 <1.3.0)
+//    - <version> with wildcards (1.x, 1.2.*)
+//    - <version>: an exact version, or stability alias (e.g., 1-latest)
 // Where <comparison> can be any one of the following comparison
 //   operators: <=, <, >, >=, ==
-// And <version> is specified in the form: X.Y.Z where X, Y and Z are all
-// int64 types in decimal form
+// And <version> is specified in the form: X.Y.Z[-prerelease] where X, Y and Z
+// are all int64 types in decimal form
 func LoadVersionMatches(versStr string) ([]interfaces.VersionReqInterface, error) {
-	var err error
-
 	versMatches := []interfaces.VersionReqInterface{}
 
-	re, err := regexp.Compile(`(<=|>=|==|>|<)([\d\.]+)`)
-	if err != nil {
-		return nil, err
-	}
+	for _, constraint := range strings.Split(versStr, ",") {
+		rest := constraint
+		for strings.TrimSpace(rest) != "" {
+			match := versionConstraintRe.FindStringSubmatch(rest)
+			if match == nil {
+				return nil, util.NewNewtError(fmt.Sprintf("Invalid version "+
+					"requirement: %s", versStr))
+			}
+			rest = rest[len(match[0]):]
 
-	matches := re.FindAllStringSubmatch(versStr, -1)
-	if matches != nil {
-		for _, match := range matches {
-			vm := &VersionMatch{}
-			vm.compareType = match[1]
-			if vm.Vers, err = LoadVersion(match[2]); err != nil {
+			vms, err := loadConstraintMatches(match[1], match[2])
+			if err != nil {
 				return nil, err
 			}
-
-			if vm.Vers != nil {
+			for _, vm := range vms {
 				versMatches = append(versMatches, vm)
 			}
 		}
-	} else {
-		vm := &VersionMatch{}
-		vm.compareType = "=="
-		if vm.Vers, err = LoadVersion(versStr); err != nil {
-			return nil, err
-		}
-
-		if vm.Vers != nil {
-			versMatches = append(versMatches, vm)
-		}
 	}
 
 	if len(versMatches) == 0 {
@@ -0,0 +1,544 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Schemas describing the settings in newt's YAML files (pkg.yml, target.yml,
+// compiler.yml, project.yml), and validation of files against them.
+package schema
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/yaml"
+)
+
+type ValueType int
+
+const (
+	// A scalar.
+	TYPE_STRING ValueType = iota
+
+	// A sequence of scalars, or a whitespace-separated string.
+	TYPE_STRING_LIST
+
+	// true or false.
+	TYPE_BOOL
+)
+
+var valueTypeNames = map[ValueType]string{
+	TYPE_STRING:      "a string",
+	TYPE_STRING_LIST: "a list of strings",
+	TYPE_BOOL:        "a boolean",
+}
+
+type SuffixKind int
+
+const (
+	// The key cannot be extended.
+	SUFFIX_NONE SuffixKind = iota
+
+	// The key can be conditioned on features: <key>.<EXPR> or
+	// <key>.<EXPR>.OVERWRITE, where <EXPR> is a feature expression (e.g.,
+	// 'BLE && !SELFTEST').
+	SUFFIX_FEATURE
+
+	// The key can be extended with any subkeys (e.g., named flag sets).
+	SUFFIX_ANY
+)
+
+// The suffix which replaces, rather than appends to, a key's value when a
+// feature is enabled.
+const FEATURE_OVERWRITE = "OVERWRITE"
+
+// A setting that may appear in a file.
+type Key struct {
+	// Dotted name of the setting.  A "*" component matches any single
+	// component (e.g., "repository.*.type").
+	Name string
+
+	Type   ValueType
+	Suffix SuffixKind
+
+	// If not empty, the only values the setting may take.
+	Values []string
+
+	// Whether the setting's values name packages.
+	PackageRef bool
+}
+
+type Schema struct {
+	// Description of the file type (e.g., "pkg.yml").
+	Name string
+	Keys []*Key
+}
+
+// A problem found in a file.
+type Problem struct {
+	File string
+	Line int
+	Key  string
+	Msg  string
+
+	// Whether the problem prevents the file from being loaded; otherwise, it
+	// is only reported by newt lint.
+	Fatal bool
+}
+
+func (p *Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Msg)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Msg)
+}
+
+// A setting read from a file, and the schema key it matches.
+type Setting struct {
+	Name  string
+	Value interface{}
+	Line  int
+
+	// Nil if the setting is unknown.
+	Key *Key
+
+	// Feature expression the setting is conditioned on, if any.
+	Feature string
+}
+
+// Returns the setting's values as strings: one for a scalar, each element of
+// a list.
+func (s *Setting) Strings() []string {
+	switch v := s.Value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		strs := []string{}
+		for _, item := range v {
+			strs = append(strs, fmt.Sprint(item))
+		}
+		return strs
+	default:
+		str := fmt.Sprint(v)
+		if s.Key != nil && s.Key.Type == TYPE_STRING_LIST {
+			return strings.Fields(str)
+		}
+		if str == "" {
+			return nil
+		}
+		return []string{str}
+	}
+}
+
+// A file validated against a schema.
+type File struct {
+	Path     string
+	Schema   *Schema
+	Settings []*Setting
+	Problems []*Problem
+}
+
+func (f *File) addProblem(s *Setting, fatal bool, format string,
+	args ...interface{}) {
+
+	p := &Problem{
+		File:  f.Path,
+		Msg:   fmt.Sprintf(format, args...),
+		Fatal: fatal,
+	}
+	if s != nil {
+		p.Line = s.Line
+		p.Key = s.Name
+	}
+	f.Problems = append(f.Problems, p)
+}
+
+// Adds a problem concerning the specified setting.
+func (f *File) AddProblem(s *Setting, format string, args ...interface{}) {
+	f.addProblem(s, false, format, args...)
+}
+
+// Returns an error describing the problems that prevent the file from being
+// loaded, or nil if there are none.
+func (f *File) FatalError() error {
+	msgs := []string{}
+	for _, p := range f.Problems {
+		if p.Fatal {
+			msgs = append(msgs, p.String())
+		}
+	}
+
+	if len(msgs) == 0 {
+		return nil
+	}
+	return util.NewNewtError(strings.Join(msgs, "\n"))
+}
+
+// Returns the value of the specified setting, flattened as in Flatten.
+func (f *File) Values() map[string]interface{} {
+	values := map[string]interface{}{}
+	for _, s := range f.Settings {
+		values[s.Name] = s.Value
+	}
+	return values
+}
+
+// Flattens nested maps into dotted keys.
+func Flatten(settings map[string]interface{}) map[string]interface{} {
+	flat := map[string]interface{}{}
+	flattenInto(flat, "", settings)
+	return flat
+}
+
+func flattenInto(flat map[string]interface{}, prefix string,
+	settings map[string]interface{}) {
+
+	for k, v := range settings {
+		name := prefix + k
+		switch val := v.(type) {
+		case map[string]interface{}:
+			flattenInto(flat, name+".", val)
+		case map[interface{}]interface{}:
+			sub := map[string]interface{}{}
+			for subk, subv := range val {
+				sub[fmt.Sprint(subk)] = subv
+			}
+			flattenInto(flat, name+".", sub)
+		default:
+			flat[name] = v
+		}
+	}
+}
+
+var keyLineRe = regexp.MustCompile(`^(\s*)("[^"]*"|'[^']*'|[^\s#\-"'][^:#]*?)\s*:(\s|$)`)
+
+type lineKey struct {
+	indent int
+	name   string
+}
+
+// Determines the line of each key in the YAML text.  Keys nested in maps are
+// joined to their parents' with dots.  Keys are lowercased.
+func keyLines(text string) map[string]int {
+	lines := map[string]int{}
+	stack := []lineKey{}
+
+	for i, line := range strings.Split(text, "\n") {
+		m := keyLineRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		indent := len(m[1])
+		name := m[2]
+		if name[0] == '"' || name[0] == '\'' {
+			name = name[1 : len(name)-1]
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, lineKey{indent, name})
+
+		names := make([]string, len(stack))
+		for j, lk := range stack {
+			names[j] = lk.name
+		}
+		// A repeated key replaces the earlier value, so the last
+		// occurrence is the one that counts.
+		lines[strings.ToLower(strings.Join(names, "."))] = i + 1
+	}
+
+	return lines
+}
+
+func matchComponents(pattern []string, comps []string) bool {
+	if len(comps) < len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && !strings.EqualFold(p, comps[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Finds the key which the specified setting name matches, along with the
+// feature the setting is conditioned on.
+func (s *Schema) match(name string) (*Key, string) {
+	comps := strings.Split(name, ".")
+
+	var best *Key
+	bestFeature := ""
+	bestLen := 0
+	for _, k := range s.Keys {
+		pattern := strings.Split(k.Name, ".")
+		if len(pattern) <= bestLen || !matchComponents(pattern, comps) {
+			continue
+		}
+
+		rest := comps[len(pattern):]
+		feature := ""
+		switch {
+		case len(rest) == 0:
+		case k.Suffix == SUFFIX_ANY:
+		case k.Suffix == SUFFIX_FEATURE && len(rest) == 1:
+			feature = rest[0]
+		case k.Suffix == SUFFIX_FEATURE && len(rest) == 2 &&
+			strings.EqualFold(rest[1], FEATURE_OVERWRITE):
+			feature = rest[0]
+		default:
+			continue
+		}
+
+		best = k
+		bestFeature = feature
+		bestLen = len(pattern)
+	}
+
+	return best, bestFeature
+}
+
+// Returns the edit distance between two strings.
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = util.Min(util.Min(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+// Suggests a known setting for a misspelled one.
+func (s *Schema) suggest(name string) string {
+	comps := strings.Split(name, ".")
+
+	best := ""
+	bestDist := 0
+	for _, k := range s.Keys {
+		pattern := strings.Split(k.Name, ".")
+		if len(comps) < len(pattern) {
+			continue
+		}
+
+		// Compare the components covered by the key, substituting the
+		// setting's own components for wildcards.
+		candidate := make([]string, len(pattern))
+		for i, p := range pattern {
+			if p == "*" {
+				candidate[i] = comps[i]
+			} else {
+				candidate[i] = p
+			}
+		}
+		prefix := strings.ToLower(strings.Join(comps[:len(pattern)], "."))
+		dist := editDistance(prefix, strings.Join(candidate, "."))
+
+		maxDist := util.Max(2, len(prefix)/4)
+		if dist == 0 || dist > maxDist {
+			continue
+		}
+		if best == "" || dist < bestDist {
+			best = strings.Join(append(candidate, comps[len(pattern):]...),
+				".")
+			bestDist = dist
+		}
+	}
+
+	return best
+}
+
+func stringInSlice(str string, slice []string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case []interface{}, map[string]interface{}, map[interface{}]interface{}:
+		return false
+	default:
+		return true
+	}
+}
+
+func (f *File) checkSetting(s *Setting) {
+	k := s.Key
+
+	if s.Feature != "" {
+		if _, err := newtutil.ParseFeatureCondition(s.Feature); err != nil {
+			f.addProblem(s, true, "%s: %s", s.Name,
+				err.(*util.NewtError).Text)
+			return
+		}
+	}
+
+	switch k.Type {
+	case TYPE_STRING:
+		if !isScalar(s.Value) {
+			f.addProblem(s, true, "%s must be %s", s.Name,
+				valueTypeNames[k.Type])
+			return
+		}
+
+	case TYPE_STRING_LIST:
+		if items, ok := s.Value.([]interface{}); ok {
+			for _, item := range items {
+				if !isScalar(item) {
+					f.addProblem(s, true, "%s must be %s", s.Name,
+						valueTypeNames[k.Type])
+					return
+				}
+			}
+		} else if !isScalar(s.Value) {
+			f.addProblem(s, true, "%s must be %s", s.Name,
+				valueTypeNames[k.Type])
+			return
+		}
+
+	case TYPE_BOOL:
+		str := ""
+		if s.Value != nil {
+			str = fmt.Sprint(s.Value)
+		}
+		if !isScalar(s.Value) {
+			f.addProblem(s, true, "%s must be %s", s.Name,
+				valueTypeNames[k.Type])
+			return
+		} else if _, err := strconv.ParseBool(str); err != nil &&
+			str != "" {
+
+			f.addProblem(s, true, "%s must be %s; got \"%s\"", s.Name,
+				valueTypeNames[k.Type], str)
+			return
+		}
+	}
+
+	if len(k.Values) > 0 {
+		for _, val := range s.Strings() {
+			if !stringInSlice(val, k.Values) {
+				f.addProblem(s, true, "invalid value for %s: \"%s\"; must "+
+					"be one of: %s", s.Name, val,
+					strings.Join(k.Values, ", "))
+			}
+		}
+	}
+}
+
+// Reads the specified YAML file and checks each setting in it against the
+// schema.  Unknown settings, values of the wrong type, and invalid values
+// are recorded as problems in the returned file.  An error is returned only
+// if the file cannot be read or parsed.
+func (s *Schema) ValidateFile(path string) (*File, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.NewNewtError(err.Error())
+	}
+
+	return s.Validate(path, contents)
+}
+
+// Checks the settings in the specified YAML text against the schema.
+func (s *Schema) Validate(path string, contents []byte) (*File, error) {
+	settings := map[string]interface{}{}
+	if err := yaml.Unmarshal(contents, settings); err != nil {
+		return nil, util.NewNewtError(fmt.Sprintf("Error reading %s: %s",
+			path, err.Error()))
+	}
+
+	lines := keyLines(string(contents))
+
+	f := &File{
+		Path:   path,
+		Schema: s,
+	}
+
+	flat := Flatten(settings)
+	names := make([]string, 0, len(flat))
+	for name, _ := range flat {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		setting := &Setting{
+			Name:  name,
+			Value: flat[name],
+			Line:  lines[strings.ToLower(name)],
+		}
+		setting.Key, setting.Feature = s.match(name)
+		f.Settings = append(f.Settings, setting)
+
+		if setting.Key == nil {
+			if suggestion := s.suggest(name); suggestion != "" {
+				f.AddProblem(setting, "unknown setting %s in %s; did you "+
+					"mean %s?", name, s.Name, suggestion)
+			} else {
+				f.AddProblem(setting, "unknown setting %s in %s", name,
+					s.Name)
+			}
+			continue
+		}
+
+		f.checkSetting(setting)
+	}
+
+	f.SortProblems()
+	return f, nil
+}
+
+// Sorts the file's problems by line number.
+func (f *File) SortProblems() {
+	sort.Stable(problemSorter(f.Problems))
+}
+
+type problemSorter []*Problem
+
+func (ps problemSorter) Len() int {
+	return len(ps)
+}
+
+func (ps problemSorter) Less(i, j int) bool {
+	return ps[i].Line < ps[j].Line
+}
+
+func (ps problemSorter) Swap(i, j int) {
+	ps[i], ps[j] = ps[j], ps[i]
+}
@@ -0,0 +1,143 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package schema
+
+// Names of the package types; must match pkg.PackageTypeNames.
+var PackageTypeValues = []string{"app", "bsp", "compiler", "lib", "target"}
+
+// Names of the system configuration setting types; must match
+// syscfg.SettingTypeNames.
+var SyscfgTypeValues = []string{"int", "bool", "string"}
+
+var PackageSchema = &Schema{
+	Name: "pkg.yml",
+	Keys: []*Key{
+		{Name: "pkg.name", Type: TYPE_STRING},
+		{Name: "pkg.type", Type: TYPE_STRING, Values: PackageTypeValues},
+		{Name: "pkg.description", Type: TYPE_STRING},
+		{Name: "pkg.author", Type: TYPE_STRING},
+		{Name: "pkg.homepage", Type: TYPE_STRING},
+		{Name: "pkg.keywords", Type: TYPE_STRING_LIST},
+		{Name: "pkg.version", Type: TYPE_STRING},
+
+		{Name: "pkg.deps", Type: TYPE_STRING_LIST, Suffix: SUFFIX_FEATURE,
+			PackageRef: true},
+		{Name: "pkg.apis", Type: TYPE_STRING_LIST, Suffix: SUFFIX_FEATURE},
+		{Name: "pkg.req_apis", Type: TYPE_STRING_LIST,
+			Suffix: SUFFIX_FEATURE},
+		{Name: "pkg.features", Type: TYPE_STRING_LIST,
+			Suffix: SUFFIX_FEATURE},
+		{Name: "pkg.cflags", Type: TYPE_STRING_LIST, Suffix: SUFFIX_FEATURE},
+		{Name: "pkg.lflags", Type: TYPE_STRING_LIST, Suffix: SUFFIX_FEATURE},
+		{Name: "pkg.aflags", Type: TYPE_STRING_LIST, Suffix: SUFFIX_FEATURE},
+
+		// BSP settings.
+		{Name: "pkg.arch", Type: TYPE_STRING, Suffix: SUFFIX_FEATURE},
+		{Name: "pkg.compiler", Type: TYPE_STRING, Suffix: SUFFIX_FEATURE,
+			PackageRef: true},
+		{Name: "pkg.linkerscript", Type: TYPE_STRING,
+			Suffix: SUFFIX_FEATURE},
+		{Name: "pkg.downloadscript", Type: TYPE_STRING,
+			Suffix: SUFFIX_FEATURE},
+		{Name: "pkg.debugscript", Type: TYPE_STRING, Suffix: SUFFIX_FEATURE},
+
+		// Size budgets (pkg.size_budget.<region>) and stack analysis.
+		{Name: "pkg.size_budget.*", Type: TYPE_STRING},
+		{Name: "pkg.stack_entries", Type: TYPE_STRING_LIST},
+
+		// System configuration: settings defined by the package, and values
+		// for settings defined by other packages.
+		{Name: "pkg.syscfg_defs.*.description", Type: TYPE_STRING},
+		{Name: "pkg.syscfg_defs.*.type", Type: TYPE_STRING,
+			Values: SyscfgTypeValues},
+		{Name: "pkg.syscfg_defs.*.value", Type: TYPE_STRING},
+		{Name: "pkg.syscfg_vals.*", Type: TYPE_STRING},
+
+		// Init functions, and the stage in which each is called.
+		{Name: "pkg.init.*", Type: TYPE_STRING},
+	},
+}
+
+var TargetSchema = &Schema{
+	Name: "target.yml",
+	Keys: []*Key{
+		{Name: "target.app", Type: TYPE_STRING, PackageRef: true},
+		{Name: "target.bsp", Type: TYPE_STRING, PackageRef: true},
+		{Name: "target.build_profile", Type: TYPE_STRING},
+		{Name: "target.extends", Type: TYPE_STRING, PackageRef: true},
+		{Name: "target.budget_policy", Type: TYPE_STRING,
+			Values: []string{"error", "warn"}},
+		{Name: "target.region_budget.*", Type: TYPE_STRING},
+	},
+}
+
+var CompilerSchema = &Schema{
+	Name: "compiler.yml",
+	Keys: []*Key{
+		{Name: "compiler.path.cc", Type: TYPE_STRING, Suffix: SUFFIX_FEATURE},
+		{Name: "compiler.path.as", Type: TYPE_STRING, Suffix: SUFFIX_FEATURE},
+		{Name: "compiler.path.archive", Type: TYPE_STRING,
+			Suffix: SUFFIX_FEATURE},
+		{Name: "compiler.path.objdump", Type: TYPE_STRING,
+			Suffix: SUFFIX_FEATURE},
+		{Name: "compiler.path.objsize", Type: TYPE_STRING,
+			Suffix: SUFFIX_FEATURE},
+		{Name: "compiler.path.objcopy", Type: TYPE_STRING,
+			Suffix: SUFFIX_FEATURE},
+
+		// Flag lists can be split into named sets (e.g.,
+		// compiler.flags.base), which other flag lists refer to.
+		{Name: "compiler.flags", Type: TYPE_STRING_LIST, Suffix: SUFFIX_ANY},
+		{Name: "compiler.as.flags", Type: TYPE_STRING_LIST,
+			Suffix: SUFFIX_ANY},
+		{Name: "compiler.ld.flags", Type: TYPE_STRING_LIST,
+			Suffix: SUFFIX_ANY},
+
+		{Name: "compiler.ld.resolve_circular_deps", Type: TYPE_BOOL,
+			Suffix: SUFFIX_FEATURE},
+		{Name: "compiler.ld.mapfile", Type: TYPE_BOOL,
+			Suffix: SUFFIX_FEATURE},
+	},
+}
+
+var ProjectSchema = &Schema{
+	Name: "project.yml",
+	Keys: []*Key{
+		{Name: "project.name", Type: TYPE_STRING},
+		{Name: "project.repositories", Type: TYPE_STRING_LIST},
+		{Name: "project.pkg_dirs", Type: TYPE_STRING_LIST},
+
+		{Name: "repository.*.type", Type: TYPE_STRING,
+			Values: []string{"github", "git", "local"}},
+		{Name: "repository.*.vers", Type: TYPE_STRING},
+		{Name: "repository.*.user", Type: TYPE_STRING},
+		{Name: "repository.*.repo", Type: TYPE_STRING},
+		{Name: "repository.*.url", Type: TYPE_STRING},
+		{Name: "repository.*.path", Type: TYPE_STRING},
+		{Name: "repository.*.desc_sha256", Type: TYPE_STRING},
+	},
+}
+
+// The schemas of the files in a package directory, by file name.
+var PackageFileSchemas = map[string]*Schema{
+	"pkg.yml":      PackageSchema,
+	"target.yml":   TargetSchema,
+	"compiler.yml": CompilerSchema,
+}
@@ -0,0 +1,411 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// System configuration: typed settings which packages define in pkg.yml
+// (pkg.syscfg_defs), and which other packages override (pkg.syscfg_vals).
+// The resolved settings are written to a header, syscfg.h, as MYNEWT_VAL_
+// macros.
+package syscfg
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+const PKG_SYSCFG_DEFS = "pkg.syscfg_defs"
+const PKG_SYSCFG_VALS = "pkg.syscfg_vals"
+
+// Fields of a setting definition.
+const (
+	DEF_DESCRIPTION = "description"
+	DEF_TYPE        = "type"
+	DEF_VALUE       = "value"
+)
+
+// The header is included as <syscfg/syscfg.h>.
+const HEADER_SUBDIR = "syscfg"
+const HEADER_FILENAME = "syscfg.h"
+
+const MACRO_PREFIX = "MYNEWT_VAL_"
+
+type SettingType int
+
+const (
+	SETTING_TYPE_INT SettingType = iota
+	SETTING_TYPE_BOOL
+	SETTING_TYPE_STRING
+)
+
+var SettingTypeNames = map[SettingType]string{
+	SETTING_TYPE_INT:    "int",
+	SETTING_TYPE_BOOL:   "bool",
+	SETTING_TYPE_STRING: "string",
+}
+
+// Settings become C macros, so their names must be valid identifiers.
+var settingNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// The precedence of overrides by package type; an override replaces those
+// of packages with lower precedence.  Overrides from packages with the same
+// precedence must agree.
+var overridePriorities = map[interfaces.PackageType]int{
+	pkg.PACKAGE_TYPE_LIB:      0,
+	pkg.PACKAGE_TYPE_COMPILER: 0,
+	pkg.PACKAGE_TYPE_BSP:      1,
+	pkg.PACKAGE_TYPE_APP:      2,
+	pkg.PACKAGE_TYPE_TARGET:   3,
+}
+
+// The priority of a setting's default value; any override replaces it.
+const DEFAULT_PRIORITY = -1
+
+type Setting struct {
+	// Upper case.
+	Name        string
+	Description string
+	Type        SettingType
+
+	// The value in effect, normalized for its type: booleans are 0 or 1.
+	Value string
+
+	// Name of the package which defines the setting.
+	DefinedBy string
+
+	// Name of the package whose value is in effect; the defining package if
+	// the setting has its default value.
+	SetBy string
+
+	priority int
+}
+
+type Cfg struct {
+	Settings map[string]*Setting
+}
+
+func ParseSettingType(name string) (SettingType, error) {
+	for t, tname := range SettingTypeNames {
+		if tname == name {
+			return t, nil
+		}
+	}
+
+	return 0, util.FmtNewtError("invalid type \"%s\"; must be one of: "+
+		"int, bool, string", name)
+}
+
+// Checks that a value is valid for the specified type, and returns it in
+// normalized form.
+func normalizeValue(t SettingType, value interface{}) (string, error) {
+	str := strings.TrimSpace(fmt.Sprint(value))
+
+	switch t {
+	case SETTING_TYPE_INT:
+		if _, err := strconv.ParseInt(str, 0, 64); err != nil {
+			return "", util.FmtNewtError("\"%s\" is not an int", str)
+		}
+		return str, nil
+
+	case SETTING_TYPE_BOOL:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return "", util.FmtNewtError("\"%s\" is not a bool", str)
+		}
+		if b {
+			return "1", nil
+		}
+		return "0", nil
+
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// Returns the value of the setting as a C expression.
+func (s *Setting) CValue() string {
+	if s.Type == SETTING_TYPE_STRING {
+		return strconv.Quote(s.Value)
+	}
+	return "(" + s.Value + ")"
+}
+
+func (s *Setting) MacroName() string {
+	return MACRO_PREFIX + s.Name
+}
+
+func sortedPackages(lpkgs []*pkg.LocalPackage) []*pkg.LocalPackage {
+	sorted := make([]*pkg.LocalPackage, len(lpkgs))
+	copy(sorted, lpkgs)
+	sort.Sort(packageSorter(sorted))
+	return sorted
+}
+
+type packageSorter []*pkg.LocalPackage
+
+func (ps packageSorter) Len() int {
+	return len(ps)
+}
+
+func (ps packageSorter) Less(i, j int) bool {
+	pi := overridePriorities[ps[i].Type()]
+	pj := overridePriorities[ps[j].Type()]
+	if pi != pj {
+		return pi < pj
+	}
+	return ps[i].FullName() < ps[j].FullName()
+}
+
+func (ps packageSorter) Swap(i, j int) {
+	ps[i], ps[j] = ps[j], ps[i]
+}
+
+// Collects the settings a package defines, specified either as a map under
+// pkg.syscfg_defs or as flattened pkg.syscfg_defs.<name>.<field> entries.
+// Setting names are upper case; field names are lower case.
+func settingDefs(lpkg *pkg.LocalPackage) map[string]map[string]interface{} {
+	defs := map[string]map[string]interface{}{}
+	addField := func(name string, field string, value interface{}) {
+		upperName := strings.ToUpper(name)
+		if defs[upperName] == nil {
+			defs[upperName] = map[string]interface{}{}
+		}
+		defs[upperName][strings.ToLower(field)] = value
+	}
+
+	v := lpkg.Viper
+	for name, fields := range v.GetStringMap(PKG_SYSCFG_DEFS) {
+		for field, value := range cast.ToStringMap(fields) {
+			addField(name, field, value)
+		}
+	}
+
+	prefix := PKG_SYSCFG_DEFS + "."
+	for _, k := range v.AllKeys() {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(k, prefix)
+		if i := strings.Index(rest, "."); i >= 0 {
+			addField(rest[:i], rest[i+1:], v.Get(k))
+		} else {
+			for field, value := range cast.ToStringMap(v.Get(k)) {
+				addField(rest, field, value)
+			}
+		}
+	}
+
+	return defs
+}
+
+func (cfg *Cfg) readDefs(lpkg *pkg.LocalPackage) error {
+	defs := settingDefs(lpkg)
+
+	names := make([]string, 0, len(defs))
+	for name, _ := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, upperName := range names {
+		if !settingNameRe.MatchString(upperName) {
+			return util.FmtNewtError("Package %s defines setting with "+
+				"invalid name: \"%s\"", lpkg.FullName(), upperName)
+		}
+
+		if other := cfg.Settings[upperName]; other != nil {
+			return util.FmtNewtError("Setting %s is defined by both %s "+
+				"and %s", upperName, other.DefinedBy, lpkg.FullName())
+		}
+
+		fields := defs[upperName]
+
+		t, err := ParseSettingType(cast.ToString(fields[DEF_TYPE]))
+		if err != nil {
+			return util.FmtNewtError("Package %s: setting %s: %s",
+				lpkg.FullName(), upperName, err.(*util.NewtError).Text)
+		}
+
+		rawValue, ok := fields[DEF_VALUE]
+		if !ok || rawValue == nil {
+			return util.FmtNewtError("Package %s: setting %s has no "+
+				"default value", lpkg.FullName(), upperName)
+		}
+		value, err := normalizeValue(t, rawValue)
+		if err != nil {
+			return util.FmtNewtError("Package %s: invalid default value "+
+				"for setting %s: %s", lpkg.FullName(), upperName,
+				err.(*util.NewtError).Text)
+		}
+
+		cfg.Settings[upperName] = &Setting{
+			Name:        upperName,
+			Description: cast.ToString(fields[DEF_DESCRIPTION]),
+			Type:        t,
+			Value:       value,
+			DefinedBy:   lpkg.FullName(),
+			SetBy:       lpkg.FullName(),
+			priority:    DEFAULT_PRIORITY,
+		}
+	}
+
+	return nil
+}
+
+func (cfg *Cfg) readVals(lpkg *pkg.LocalPackage) error {
+	vals := newtutil.GetStringMapFlat(lpkg.Viper, PKG_SYSCFG_VALS)
+	priority := overridePriorities[lpkg.Type()]
+
+	names := make([]string, 0, len(vals))
+	for name, _ := range vals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		upperName := strings.ToUpper(name)
+		s := cfg.Settings[upperName]
+		if s == nil {
+			return util.FmtNewtError("Package %s overrides undefined "+
+				"setting %s", lpkg.FullName(), upperName)
+		}
+
+		value, err := normalizeValue(s.Type, vals[name])
+		if err != nil {
+			return util.FmtNewtError("Package %s: invalid value for "+
+				"setting %s (%s): %s", lpkg.FullName(), upperName,
+				SettingTypeNames[s.Type], err.(*util.NewtError).Text)
+		}
+
+		switch {
+		case priority > s.priority:
+			s.Value = value
+			s.SetBy = lpkg.FullName()
+			s.priority = priority
+
+		case priority == s.priority && value != s.Value:
+			return util.FmtNewtError("Conflicting overrides of setting "+
+				"%s: %s sets %s, %s sets %s", upperName, s.SetBy, s.Value,
+				lpkg.FullName(), value)
+		}
+	}
+
+	return nil
+}
+
+// Resolves the settings defined and overridden by the specified packages.
+// Packages override settings in order of precedence (lib, bsp, app,
+// target); an error is returned if a package overrides an undefined setting
+// or specifies a value of the wrong type, or if two packages with the same
+// precedence specify different values.
+func Read(lpkgs []*pkg.LocalPackage) (*Cfg, error) {
+	cfg := &Cfg{
+		Settings: map[string]*Setting{},
+	}
+
+	sorted := sortedPackages(lpkgs)
+	for _, lpkg := range sorted {
+		if err := cfg.readDefs(lpkg); err != nil {
+			return nil, err
+		}
+	}
+	for _, lpkg := range sorted {
+		if err := cfg.readVals(lpkg); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// Returns the settings, sorted by name.
+func (cfg *Cfg) SortedSettings() []*Setting {
+	names := make([]string, 0, len(cfg.Settings))
+	for name, _ := range cfg.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	settings := make([]*Setting, len(names))
+	for i, name := range names {
+		settings[i] = cfg.Settings[name]
+	}
+	return settings
+}
+
+// Generates the contents of syscfg.h.  Each setting is guarded by #ifndef,
+// so that it can still be overridden on the command line (e.g., with
+// --cflag).
+func (cfg *Cfg) HeaderText() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("/**\n")
+	buffer.WriteString(" * This file was generated by " +
+		newtutil.NewtVersionStr + "\n")
+	buffer.WriteString(" */\n\n")
+	buffer.WriteString("#ifndef H_MYNEWT_SYSCFG_\n")
+	buffer.WriteString("#define H_MYNEWT_SYSCFG_\n\n")
+	buffer.WriteString("#define MYNEWT_VAL(x) " + MACRO_PREFIX + "##x\n")
+
+	for _, s := range cfg.SortedSettings() {
+		buffer.WriteString("\n")
+		if s.Description != "" {
+			buffer.WriteString("/* " +
+				strings.Replace(s.Description, "*/", "* /", -1) + " */\n")
+		}
+		if s.SetBy == s.DefinedBy {
+			buffer.WriteString(fmt.Sprintf("/* Defined by %s. */\n",
+				s.DefinedBy))
+		} else {
+			buffer.WriteString(fmt.Sprintf("/* Defined by %s; set by "+
+				"%s. */\n", s.DefinedBy, s.SetBy))
+		}
+		buffer.WriteString("#ifndef " + s.MacroName() + "\n")
+		buffer.WriteString("#define " + s.MacroName() + " " + s.CValue() +
+			"\n")
+		buffer.WriteString("#endif\n")
+	}
+
+	buffer.WriteString("\n#endif\n")
+	return buffer.String()
+}
+
+// Returns the path of syscfg.h within the specified include directory.
+func HeaderPath(includeDir string) string {
+	return filepath.Join(includeDir, HEADER_SUBDIR, HEADER_FILENAME)
+}
+
+// Writes syscfg.h to the specified include directory.  The file is only
+// written if its contents change, so that an unchanged configuration doesn't
+// cause a rebuild.
+func (cfg *Cfg) WriteHeader(includeDir string) error {
+	return newtutil.WriteFileIfChanged(HeaderPath(includeDir),
+		[]byte(cfg.HeaderText()))
+}
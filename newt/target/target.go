@@ -28,6 +28,7 @@ import (
 	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/newt/project"
 	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/newt/schema"
 	"mynewt.apache.org/newt/util"
 	"mynewt.apache.org/newt/yaml"
 )
@@ -35,6 +36,20 @@ import (
 const TARGET_FILENAME string = "target.yml"
 const DEFAULT_BUILD_PROFILE string = "default"
 
+// Variable naming the target which a target inherits its settings from.
+const TARGET_EXTENDS string = "target.extends"
+
+// Settings of a target's package which are inherited along with its
+// target.yml variables.  Feature-specific settings (e.g., pkg.cflags.TEST)
+// are inherited too.
+var inheritedPkgSettings = []string{
+	"pkg.features",
+	"pkg.cflags",
+	"pkg.lflags",
+	"pkg.aflags",
+	"pkg.syscfg_vals",
+}
+
 var globalTargetMap map[string]*Target
 
 type Target struct {
@@ -44,8 +59,16 @@ type Target struct {
 	AppName      string
 	BuildProfile string
 
-	// target.yml configuration structure
+	// target.yml configuration structure, including the variables inherited
+	// from the target this one extends.
 	Vars map[string]string
+
+	// Name of the target each inherited setting comes from, for target.yml
+	// variables and inherited package settings.
+	sources map[string]string
+
+	// Values of the inherited target.yml variables; these are not saved.
+	inheritedVars map[string]string
 }
 
 func NewTarget(basePkg *pkg.LocalPackage) *Target {
@@ -68,17 +91,35 @@ func (target *Target) Init(basePkg *pkg.LocalPackage) {
 }
 
 func (target *Target) Load(basePkg *pkg.LocalPackage) error {
-	v, err := util.ReadConfig(basePkg.BasePath(),
-		strings.TrimSuffix(TARGET_FILENAME, ".yml"))
+	return target.load(basePkg, nil)
+}
+
+// Loads the target; chain lists the targets which extend this one, for
+// detecting cycles.
+func (target *Target) load(basePkg *pkg.LocalPackage, chain []string) error {
+	f, err := schema.TargetSchema.ValidateFile(basePkg.BasePath() +
+		TARGET_FILENAME)
 	if err != nil {
 		return err
 	}
+	if err := f.FatalError(); err != nil {
+		return err
+	}
 
+	// Target variables are strings; settings that newt doesn't know about
+	// are kept as they are, with lists joined by spaces.  Keys are
+	// lowercase, as viper is case-insensitive.
 	target.Vars = map[string]string{}
+	for _, s := range f.Settings {
+		target.Vars[strings.ToLower(s.Name)] = strings.Join(s.Strings(), " ")
+	}
 
-	settings := v.AllSettings()
-	for k, v := range settings {
-		target.Vars[k] = v.(string)
+	target.sources = map[string]string{}
+	target.inheritedVars = map[string]string{}
+	if parentName := target.Vars[TARGET_EXTENDS]; parentName != "" {
+		if err := target.inherit(parentName, chain); err != nil {
+			return err
+		}
 	}
 
 	target.BspName = target.Vars["target.bsp"]
@@ -98,6 +139,78 @@ func (target *Target) Load(basePkg *pkg.LocalPackage) error {
 	return nil
 }
 
+// Returns the inherited package setting which the specified viper key
+// belongs to, or "" if the key isn't inherited.
+func inheritedPkgSetting(key string) string {
+	for _, setting := range inheritedPkgSettings {
+		if key == setting || strings.HasPrefix(key, setting+".") {
+			return setting
+		}
+	}
+
+	return ""
+}
+
+// Merges the settings of the named target into this one.  Settings which
+// this target specifies itself take precedence.
+func (target *Target) inherit(parentName string, chain []string) error {
+	parentPkg := target.resolvePackageName(parentName)
+	if parentPkg == nil || parentPkg.Type() != pkg.PACKAGE_TYPE_TARGET {
+		return util.FmtNewtError("Target %s extends unknown target %s",
+			target.FullName(), parentName)
+	}
+
+	chain = append(chain, target.FullName())
+	for _, name := range chain {
+		if name == parentPkg.FullName() {
+			return util.FmtNewtError("Target %s extends itself: %s -> %s",
+				parentPkg.FullName(), strings.Join(chain, " -> "),
+				parentPkg.FullName())
+		}
+	}
+
+	parent := NewTarget(parentPkg)
+	if err := parent.load(parentPkg, chain); err != nil {
+		return err
+	}
+
+	for k, v := range parent.Vars {
+		if _, ok := target.Vars[k]; ok || k == TARGET_EXTENDS {
+			continue
+		}
+		target.Vars[k] = v
+		target.inheritedVars[k] = v
+		target.sources[k] = parent.Source(k)
+	}
+
+	pv := parentPkg.Viper
+	v := target.basePkg.Viper
+	for _, key := range pv.AllKeys() {
+		if inheritedPkgSetting(key) == "" || v.InConfig(key) {
+			continue
+		}
+		target.basePkg.SetInheritedValue(key, pv.Get(key))
+		target.sources[key] = parent.Source(key)
+	}
+
+	// A change to an ancestor requires a rebuild.
+	for _, filename := range parentPkg.CfgFilenames() {
+		target.basePkg.AddCfgFilename(filename)
+	}
+
+	return nil
+}
+
+// Returns the name of the target which defines the specified setting (a
+// target.yml variable or a package setting, e.g., pkg.cflags): this target,
+// or the one it inherits the setting from.
+func (target *Target) Source(key string) string {
+	if src, ok := target.sources[strings.ToLower(key)]; ok {
+		return src
+	}
+	return target.FullName()
+}
+
 func (target *Target) Validate(appRequired bool) error {
 	if target.BspName == "" {
 		return util.NewNewtError("Target does not specify a BSP package " +
@@ -219,9 +332,12 @@ func (t *Target) Save() error {
 
 	file.WriteString("### Target: " + t.Name() + "\n")
 
+	// Inherited variables belong to the target they're inherited from.
 	keys := []string{}
-	for k, _ := range t.Vars {
-		keys = append(keys, k)
+	for k, v := range t.Vars {
+		if inherited, ok := t.inheritedVars[k]; !ok || inherited != v {
+			keys = append(keys, k)
+		}
 	}
 	sort.Strings(keys)
 
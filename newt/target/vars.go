@@ -38,8 +38,12 @@ func varsFromChildDirs(key string, fullPath bool) ([]string, error) {
 	valueSlice := []string{}
 
 	repos := project.GetProject().Repos()
-	searchDirs := project.GetProject().PackageSearchDirs()
 	for _, r := range repos {
+		searchDirs, err := project.GetProject().RepoPackageSearchDirs(r)
+		if err != nil {
+			return nil, err
+		}
+
 		for _, pkgDir := range searchDirs {
 			pkgBaseDir := r.Path() + "/" + pkgDir
 			values, err := util.DescendantDirsOfParent(pkgBaseDir, key,
@@ -91,6 +95,10 @@ var varsMap = map[string]func() ([]string, error){
 	"target.app": func() ([]string, error) {
 		return varsFromPackageType(pkg.PACKAGE_TYPE_APP, true)
 	},
+
+	"target.extends": func() ([]string, error) {
+		return varsFromPackageType(pkg.PACKAGE_TYPE_TARGET, true)
+	},
 }
 
 // Returns a slice of valid values for the target variable with the specified
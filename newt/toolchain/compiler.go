@@ -32,6 +32,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 
 	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/schema"
 	"mynewt.apache.org/newt/util"
 	"mynewt.apache.org/newt/viper"
 )
@@ -54,6 +55,9 @@ type Compiler struct {
 	ObjPathList  map[string]bool
 	LinkerScript string
 
+	// Whether C compiles emit stack usage (.su) files.
+	StackUsage bool
+
 	depTracker            DepTracker
 	ccPath                string
 	asPath                string
@@ -134,6 +138,15 @@ func (c *Compiler) load(compilerDir string, buildProfile string) error {
 		return err
 	}
 
+	f, err := schema.CompilerSchema.ValidateFile(compilerDir +
+		COMPILER_FILENAME)
+	if err != nil {
+		return err
+	}
+	if err := f.FatalError(); err != nil {
+		return err
+	}
+
 	features := map[string]bool{
 		buildProfile:                  true,
 		strings.ToUpper(runtime.GOOS): true,
@@ -262,6 +275,10 @@ func (c *Compiler) CompileFileCmd(file string,
 	cmd += " -c " + "-o " + objPath + " " + file +
 		" " + c.cflagsString() + " " + c.includesString()
 
+	if c.StackUsage && compilerType == COMPILER_TYPE_C {
+		cmd += " -fstack-usage"
+	}
+
 	return cmd, nil
 }
 
@@ -627,6 +644,20 @@ func (c *Compiler) PrintSize(elfFilename string) (string, error) {
 	return string(rsp), nil
 }
 
+// Disassembles the executable sections of the specified elf file.
+//
+// @param elfFilename           The filename of the elf file to disassemble.
+//
+// @return                      (success) The objdump output.
+func (c *Compiler) Disassemble(elfFilename string) (string, error) {
+	cmd := c.odPath + " -d " + elfFilename
+	rsp, err := util.ShellCommand(cmd)
+	if err != nil {
+		return "", err
+	}
+	return string(rsp), nil
+}
+
 // Links the specified elf file and generates some associated artifacts (lst,
 // bin, and map files).
 //